@@ -156,7 +156,7 @@ public class PrnmTest implements prnm.NewChannelCallback, prnm.ProposalHandler,
         }).start();
 
         client.enablePersistence(dbPath);
-        client.restore(ctx);
+        client.restore(ctx, null);
     }
 
     public void proposeChannel(Setup s) throws Exception {
@@ -90,7 +90,7 @@ public class MainActivity extends Activity {
     }
 }
 
-class Node implements prnm.NewChannelCallback, prnm.ProposalHandler, prnm.UpdateHandler, prnm.ConcludedEventHandler {
+class Node implements prnm.NewChannelCallback, prnm.ProposalHandler, prnm.UpdateHandler, prnm.EventListener {
     public Client client;
     // Since java uses _pointer comparison_ for byte[] keys in a map, we need to wrap it in ByteBuffer.
     public Map<ByteBuffer, PaymentChannel> chs = new ConcurrentHashMap<ByteBuffer, PaymentChannel>();
@@ -102,6 +102,9 @@ class Node implements prnm.NewChannelCallback, prnm.ProposalHandler, prnm.Update
             client = new Client(ctx, cfg, wallet);
             // Set the handler for new channels.
             client.onNewChannel(this);
+            // The client watches all channels itself and reports their
+            // on-chain events to the listener.
+            client.setEventListener(this);
         } finally {
             ctx.cancel();
         }
@@ -114,7 +117,7 @@ class Node implements prnm.NewChannelCallback, prnm.ProposalHandler, prnm.Update
     public void restore() throws Exception {
         Context ctx = Prnm.contextWithTimeout(20);
         try {
-            client.restore(ctx);
+            client.restore(ctx, null);
         } finally {
             ctx.cancel();
         }
@@ -173,7 +176,7 @@ class Node implements prnm.NewChannelCallback, prnm.ProposalHandler, prnm.Update
         }
     }
 
-    // Handles all new channels by calling `watch` on them.
+    // Handles all new channels by storing them.
     @Override
     public void onNew(PaymentChannel channel) {
         byte[] id = channel.getParams().getID();
@@ -183,17 +186,6 @@ class Node implements prnm.NewChannelCallback, prnm.ProposalHandler, prnm.Update
             Log.i("prnm", "New channel " + new BigInteger(1, id).toString(16));
         }
         chs.put(ByteBuffer.wrap(id), channel);
-
-        // Start a new thread for watching the channel.
-        new Thread(() -> {
-            try {
-                Log.d("channel", "Starting watching");
-                channel.watch(this);
-                Log.d("channel", "Stopped watching");
-            }  catch (Exception e) {
-                Log.e("channel", "Error watching:" + e.toString());
-            }
-        }).start();
     }
 
     // Handles all channel updates by accepting them.
@@ -213,9 +205,16 @@ class Node implements prnm.NewChannelCallback, prnm.ProposalHandler, prnm.Update
         }
     }
 
-    // Handles all channel conclusion events on the Adjudicator.
+    // Handles the conclusion events of all channels by settling them.
     @Override
-    public void handleConcluded(byte[] id) {
+    public void onEvent(Event event) {
+        if (event.getType() != Prnm.EventChannelConcluded)
+            return;
+        // onEvent must return quickly, so settle in a new thread.
+        new Thread(() -> handleConcluded(event.getChannelID())).start();
+    }
+
+    private void handleConcluded(byte[] id) {
         Log.i("channel", "Received concluded event for channel " + new BigInteger(1, id).toString(16));
         Context ctx = Prnm.contextWithTimeout(30);
         try {
@@ -0,0 +1,141 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/log"
+)
+
+// balanceQueryTimeout is the timeout of querying all balances of an account
+// after a new block.
+const balanceQueryTimeout = 10 * time.Second
+
+type (
+	// BalanceHandler is notified about changes of on-chain balances.
+	BalanceHandler interface {
+		// OnBalanceChanged is called with the new balance of `address`.
+		// `token` is nil for the ETH balance and the ERC-20 token
+		// otherwise.
+		OnBalanceChanged(address, token *Address, balance *BigInt)
+	}
+
+	// BalanceSubscription reports the balance changes of an account until it
+	// is closed.
+	BalanceSubscription struct {
+		sub  ethereum.Subscription
+		once sync.Once
+		quit chan struct{}
+	}
+)
+
+// OnChainTokenBalance returns the balance of `address` of the ERC-20
+// `token`.
+func (c *Client) OnChainTokenBalance(ctx *Context, token, address *Address) (*BigInt, error) {
+	bal, err := c.tokenBalance(ctx.ctx, common.Address(token.addr), common.Address(address.addr))
+	if err != nil {
+		return nil, toError(err)
+	}
+	return &BigInt{bal}, nil
+}
+
+// SubscribeBalances reports the ETH balance of `address` and its balances
+// of the tokens in Config.Tokens to `h`: first all current balances and then
+// every change, which is checked at every new block. The subscription ends
+// when it is closed or the Client is closed.
+func (c *Client) SubscribeBalances(ctx *Context, address *Address, h BalanceHandler) (*BalanceSubscription, error) {
+	heads := make(chan *types.Header, 16)
+	sub, err := c.ethClient.SubscribeNewHead(ctx.ctx, heads)
+	if err != nil {
+		return nil, toError(errors.WithMessage(err, "subscribing to new blocks"))
+	}
+	s := &BalanceSubscription{sub: sub, quit: make(chan struct{})}
+	go c.watchBalances(s, common.Address(address.addr), heads, h)
+	return s, nil
+}
+
+// Close ends the subscription. No more balances are reported once it
+// returns, except for an already running call of the BalanceHandler.
+func (s *BalanceSubscription) Close() {
+	s.once.Do(func() { close(s.quit) })
+}
+
+// watchBalances reports the balances of `owner` after every new block.
+func (c *Client) watchBalances(s *BalanceSubscription, owner common.Address, heads <-chan *types.Header, h BalanceHandler) {
+	defer s.sub.Unsubscribe()
+	last := make(map[common.Address]*big.Int) // by token, zero for ETH
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), balanceQueryTimeout)
+		c.reportBalances(ctx, owner, last, h)
+		cancel()
+
+		select {
+		case <-heads:
+		case err := <-s.sub.Err():
+			log.WithError(err).Error("Balance subscription failed")
+			return
+		case <-s.quit:
+			return
+		case <-c.closed:
+			return
+		}
+		// Only query once for blocks that arrived meanwhile.
+		for len(heads) > 0 {
+			<-heads
+		}
+	}
+}
+
+// reportBalances reports all balances of `owner` that differ from `last`
+// and updates `last`.
+func (c *Client) reportBalances(ctx context.Context, owner common.Address, last map[common.Address]*big.Int, h BalanceHandler) {
+	report := func(token common.Address, bal *big.Int) {
+		if prev, ok := last[token]; ok && prev.Cmp(bal) == 0 {
+			return
+		}
+		last[token] = bal
+		var t *Address
+		if token != (common.Address{}) {
+			t = &Address{ethwallet.Address(token)}
+		}
+		h.OnBalanceChanged(&Address{ethwallet.Address(owner)}, t, &BigInt{new(big.Int).Set(bal)})
+	}
+
+	if bal, err := c.ethClient.BalanceAt(ctx, owner, nil); err != nil {
+		log.WithError(err).Warn("Querying balance")
+	} else {
+		report(common.Address{}, bal)
+	}
+	for _, token := range c.tokens() {
+		if bal, err := c.tokenBalance(ctx, token, owner); err != nil {
+			log.WithError(err).WithField("token", token.Hex()).Warn("Querying token balance")
+		} else {
+			report(token, bal)
+		}
+	}
+}
+
+// tokenBalance returns the balance of `owner` of the ERC-20 `token`.
+func (c *Client) tokenBalance(ctx context.Context, token, owner common.Address) (*big.Int, error) {
+	contract := c.erc20Contract(token)
+	var out []interface{}
+	if err := contract.Call(&bind.CallOpts{Context: ctx}, &out, "balanceOf", owner); err != nil {
+		return nil, errors.WithMessage(err, "querying token balance")
+	}
+	return *abi.ConvertType(out[0], new(*big.Int)).(**big.Int), nil
+}
@@ -110,6 +110,15 @@ func NewBalances(first, second *BigInt) *BigInts {
 	return &BigInts{values: []*big.Int{first.i, second.i}}
 }
 
+// copyBigInts returns a BigInts that holds copies of `values`.
+func copyBigInts(values []*big.Int) *BigInts {
+	copied := make([]*big.Int, len(values))
+	for i, v := range values {
+		copied[i] = new(big.Int).Set(v)
+	}
+	return &BigInts{values: copied}
+}
+
 // Length returns the length of the BigInts slice.
 func (bs *BigInts) Length() int {
 	return len(bs.values)
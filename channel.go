@@ -6,11 +6,15 @@
 package prnm
 
 import (
+	"context"
+	"math/big"
+
 	"github.com/pkg/errors"
 
 	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
 	"perun.network/go-perun/channel"
 	"perun.network/go-perun/client"
+	"perun.network/go-perun/wire"
 )
 
 // State wraps a go-perun/channel.State
@@ -75,7 +79,9 @@ type (
 	// which provides all necessary functionality of a two-party payment channel.
 	// ref https://pkg.go.dev/perun.network/go-perun/client?tab=doc#Channel
 	PaymentChannel struct {
-		ch *client.Channel
+		ch      *client.Channel
+		c       *Client // back-reference to the Client that tracks the channel
+		watcher *channelWatcher
 	}
 
 	// ConcludedEventHandler handles channel conclusions.
@@ -98,34 +104,71 @@ func (w *ConcludedWatcher) HandleAdjudicatorEvent(e channel.AdjudicatorEvent) {
 	}
 }
 
-// Watch starts the channel watcher routine. It subscribes to RegisteredEvents
-// on the adjudicator. If an event is registered, it is handled by making sure
-// the latest state is registered and then all funds withdrawn to the receiver
-// specified in the adjudicator that was passed to the channel.
-// In case of a channel conclusion event, the given handler `h` is called.
+// Watch blocks until the channel is not watched anymore and calls the given
+// handler `h` in case of a channel conclusion event.
+// Use WatchEvents to be notified about registrations and progressions, too.
 //
-// If handling failed, the watcher routine returns the respective error. It is
-// the user's job to restart the watcher after the cause of the error got fixed.
+// The Client watches every channel that it opens or restores: if a state is
+// registered, it makes sure that the latest state is registered and then all
+// funds are withdrawn to the receiver specified in the adjudicator that was
+// passed to the channel. A failed watcher is restarted with backoff; every
+// failure is emitted as EventWatcherError. If it keeps failing, the
+// WatcherFailureHandler is notified and Watch returns the error.
 // ref https://pkg.go.dev/perun.network/go-perun/client?tab=doc#Channel.Watch
 func (c *PaymentChannel) Watch(h ConcludedEventHandler) error {
-	w := &ConcludedWatcher{h: h}
-	return c.ch.Watch(w)
+	return toError(c.watcher.wait(&ConcludedWatcher{h: h}))
 }
 
 // Send pays `amount` to the counterparty. Only positive amounts are supported.
 func (c *PaymentChannel) Send(ctx *Context, amount *BigInt) error {
 	if amount.i.Sign() < 1 {
-		return errors.New("Only positive amounts supported in send")
+		return newError(ErrorInvalidArgument, "", errors.New("Only positive amounts supported in send"))
 	}
 
-	return c.ch.UpdateBy(ctx.ctx, func(state *channel.State) error {
-		my := c.ch.Idx()
-		other := 1 - my
-		bals := state.Allocation.Balances[0]
-		bals[my].Sub(bals[my], amount.i)
-		bals[other].Add(bals[other], amount.i)
-		return nil
-	})
+	return toError(c.send(ctx.ctx, amount.i))
+}
+
+// send pays `amount` to the counterparty.
+func (c *PaymentChannel) send(ctx context.Context, amount *big.Int) error {
+	state, idx := c.state()
+	return c.update(ctx, paymentState(state, idx, amount), amount)
+}
+
+// paymentState returns the successor of `state` in which participant `idx`
+// paid `amount` to the other participant.
+func paymentState(state *channel.State, idx channel.Index, amount *big.Int) *channel.State {
+	next := state.Clone()
+	next.Version++
+	bals := next.Allocation.Balances[0]
+	bals[idx].Sub(bals[idx], amount)
+	bals[1-idx].Add(bals[1-idx], amount)
+	return next
+}
+
+// state returns the current state of the channel and our index in it.
+func (c *PaymentChannel) state() (*channel.State, channel.Index) {
+	return c.ch.State(), c.ch.Idx()
+}
+
+// update proposes `next`, which pays `amount` to the counterparty.
+func (c *PaymentChannel) update(ctx context.Context, next *channel.State, amount *big.Int) error {
+	if err := c.ch.Update(ctx, next); err != nil {
+		return err
+	}
+	state := c.ch.State()
+	c.c.events.emitState(EventChannelUpdated, state)
+	c.c.events.emitPayment(EventPaymentSent, state, amount)
+	return nil
+}
+
+// connect connects to the counterparty, if there is no connection.
+func (c *PaymentChannel) connect(ctx context.Context) error {
+	return c.c.connect(ctx, c.peer())
+}
+
+// peer returns the Perun ID of the counterparty.
+func (c *PaymentChannel) peer() wire.Address {
+	return c.ch.Peers()[1-c.ch.Idx()]
 }
 
 // GetIdx returns our index in the channel.
@@ -136,10 +179,15 @@ func (c *PaymentChannel) GetIdx() int {
 
 // Finalize finalizes the channel with the current state.
 func (c *PaymentChannel) Finalize(ctx *Context) error {
-	return c.ch.UpdateBy(ctx.ctx, func(state *channel.State) error {
+	err := c.ch.UpdateBy(ctx.ctx, func(state *channel.State) error {
 		state.IsFinal = true
 		return nil
 	})
+	if err != nil {
+		return toError(err)
+	}
+	c.c.events.emitState(EventChannelUpdated, c.ch.State())
+	return nil
 }
 
 // Settle settles the channel: it is made sure that the current state is
@@ -151,7 +199,12 @@ func (c *PaymentChannel) Finalize(ctx *Context) error {
 // protocol, where it is assumed that the other peer also settles the channel.
 // ref https://pkg.go.dev/perun.network/go-perun/client?tab=doc#Channel.Settle
 func (c *PaymentChannel) Settle(ctx *Context, secondary bool) error {
-	return c.ch.Settle(ctx.ctx, secondary)
+	if err := c.ch.Settle(ctx.ctx, secondary); err != nil {
+		return toError(err)
+	}
+	c.c.dropPayments(c.ch.ID())
+	c.c.events.emitState(EventChannelWithdrawn, c.ch.State())
+	return nil
 }
 
 // Close releases all resources that are associated with the channel and
@@ -159,6 +212,7 @@ func (c *PaymentChannel) Settle(ctx *Context, secondary bool) error {
 // `Close` should only be called on settled channels to prevent loss of funds.
 // ref https://pkg.go.dev/perun.network/go-perun/client?tab=doc#Channel.Close
 func (c *PaymentChannel) Close() error {
+	c.c.removeChannel(c.ch.ID())
 	return c.ch.Close()
 }
 
@@ -6,6 +6,8 @@
 package prnm
 
 import (
+	"math/big"
+
 	"perun.network/go-perun/channel"
 	"perun.network/go-perun/client"
 )
@@ -22,6 +24,7 @@ type (
 	// updateHandler implements a client.UpdateHandler wrapping a prnm
 	// UpdateHandler
 	updateHandler struct {
+		c *Client // back-reference for events
 		h UpdateHandler
 	}
 
@@ -39,7 +42,9 @@ type (
 	// Reject(). Only a single function must be called and every further call
 	// causes a panic.
 	UpdateResponder struct {
-		r *client.UpdateResponder
+		c      *Client // back-reference for events
+		update *ChannelUpdate
+		r      *client.UpdateResponder
 	}
 )
 
@@ -52,16 +57,30 @@ func (h *updateHandler) HandleUpdate(_last *channel.State, _update client.Channe
 		State:    &State{_update.State},
 		ActorIdx: int(_update.ActorIdx),
 	}
-	resp := &UpdateResponder{r: _resp}
+	resp := &UpdateResponder{c: h.c, update: update, r: _resp}
 	h.h.HandleUpdate(update, resp)
 }
 
 // Accept lets the user signal that they want to accept the channel update.
+// The update is emitted as EventChannelUpdated and, if it pays us, as
+// EventPaymentReceived.
 func (r *UpdateResponder) Accept(ctx *Context) error {
-	return r.r.Accept(ctx.ctx)
+	if err := r.r.Accept(ctx.ctx); err != nil {
+		return toError(err)
+	}
+	last, state := r.update.Last.s, r.update.State.s
+	r.c.events.emitState(EventChannelUpdated, state)
+	if pch := r.c.trackedChannel(state.ID); pch != nil && r.update.ActorIdx != pch.GetIdx() {
+		my := pch.ch.Idx()
+		amount := new(big.Int).Sub(state.Balances[0][my], last.Balances[0][my])
+		if amount.Sign() > 0 {
+			r.c.events.emitPayment(EventPaymentReceived, state, amount)
+		}
+	}
+	return nil
 }
 
 // Reject lets the user signal that they reject the channel update.
 func (r *UpdateResponder) Reject(ctx *Context, reason string) error {
-	return r.r.Reject(ctx.ctx, reason)
+	return toError(r.r.Reject(ctx.ctx, reason))
 }
@@ -0,0 +1,102 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"time"
+
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+	"perun.network/go-perun/channel"
+)
+
+type (
+	// AdjudicatorEventHandler handles all on-chain events of a channel.
+	AdjudicatorEventHandler interface {
+		// HandleRegistered is called when a state was registered, e.g. when
+		// the peer starts a dispute, possibly with an old state.
+		HandleRegistered(*AdjudicatorEvent)
+		// HandleProgressed is called when the channel was progressed.
+		HandleProgressed(*AdjudicatorEvent)
+		// HandleConcluded is called when the channel was concluded.
+		HandleConcluded(*AdjudicatorEvent)
+	}
+
+	// AdjudicatorEvent is an on-chain event of a channel.
+	AdjudicatorEvent struct {
+		e     channel.AdjudicatorEvent
+		state *channel.State // nil for ConcludedEvents
+	}
+
+	// adjudicatorWatcher implements the AdjudicatorEventHandler of go-perun
+	// and forwards all events to an AdjudicatorEventHandler.
+	adjudicatorWatcher struct {
+		h AdjudicatorEventHandler
+	}
+)
+
+// WatchEvents blocks like Watch, but passes every adjudicator event to `h`
+// instead of only conclusions.
+func (c *PaymentChannel) WatchEvents(h AdjudicatorEventHandler) error {
+	return toError(c.watcher.wait(&adjudicatorWatcher{h: h}))
+}
+
+// HandleAdjudicatorEvent handles channel events emitted by the Adjudicator.
+func (w *adjudicatorWatcher) HandleAdjudicatorEvent(e channel.AdjudicatorEvent) {
+	switch e := e.(type) {
+	case *channel.RegisteredEvent:
+		w.h.HandleRegistered(&AdjudicatorEvent{e: e, state: e.State})
+	case *channel.ProgressedEvent:
+		w.h.HandleProgressed(&AdjudicatorEvent{e: e, state: e.State})
+	case *channel.ConcludedEvent:
+		w.h.HandleConcluded(&AdjudicatorEvent{e: e})
+	}
+}
+
+// GetID returns the ID of the channel.
+func (e *AdjudicatorEvent) GetID() []byte {
+	id := e.e.ID()
+	return id[:]
+}
+
+// GetVersion returns the version of the state that the event refers to.
+func (e *AdjudicatorEvent) GetVersion() int64 {
+	return int64(e.e.Version())
+}
+
+// GetTimeout returns the end of the challenge duration or the progression
+// phase that was started by the event as unix timestamp in seconds. It is 0
+// if the timeout is already elapsed or unknown.
+func (e *AdjudicatorEvent) GetTimeout() int64 {
+	var timeout int64
+	switch t := e.e.Timeout().(type) {
+	case *ethchannel.BlockTimeout:
+		timeout = int64(t.Time)
+	case *channel.TimeTimeout:
+		timeout = t.Unix()
+	}
+	if timeout <= time.Now().Unix() {
+		return 0
+	}
+	return timeout
+}
+
+// GetState returns the state of the event or nil for conclusions. Do not
+// modify it.
+func (e *AdjudicatorEvent) GetState() *State {
+	if e.state == nil {
+		return nil
+	}
+	return &State{e.state}
+}
+
+// GetBalances returns a copy of the balances of the state of the event or
+// nil for conclusions.
+func (e *AdjudicatorEvent) GetBalances() *BigInts {
+	if e.state == nil {
+		return nil
+	}
+	return copyBigInts(e.state.Balances[0])
+}
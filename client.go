@@ -8,26 +8,30 @@ package prnm
 import (
 	"context"
 	"fmt"
-	"math/big"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/pkg/errors"
 
 	ethchannel "perun.network/go-perun/backend/ethereum/channel"
 	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
 	"perun.network/go-perun/backend/ethereum/wallet/keystore"
+	"perun.network/go-perun/channel"
 	"perun.network/go-perun/channel/persistence/keyvalue"
 	"perun.network/go-perun/client"
 	"perun.network/go-perun/log"
 	"perun.network/go-perun/pkg/sortedkv/leveldb"
 	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/watcher"
 	"perun.network/go-perun/watcher/local"
+	"perun.network/go-perun/wire"
 	"perun.network/go-perun/wire/net"
-	"perun.network/go-perun/wire/net/simple"
+
+	"github.com/perun-network/perun-eth-mobile/mdns"
+	"github.com/perun-network/perun-eth-mobile/watchtower"
 )
 
 type (
@@ -38,15 +42,40 @@ type (
 	Client struct {
 		cfg *Config
 
-		ethClient *ethclient.Client
+		ethClient *ethNodes
+		cb        ethchannel.ContractBackend
+		nonces    *nonceManager
+		txs       *txTracker
 		client    *client.Client
 		persister *keyvalue.PersistRestorer
+		db        *leveldb.Database // nil if persistence is not enabled
+
+		wallet      *keystore.Wallet
+		onChain     wallet.Account
+		adjudicator *receiverAdjudicator
+
+		dialer  peerDialer
+		bus     *net.Bus
+		monitor *connMonitor
+		tower   *watchtower.Client // nil if no watchtower is configured
+		events  eventEmitter
+		closed  chan struct{} // closed by Close
+
+		closeOnce sync.Once
 
-		wallet  *keystore.Wallet
-		onChain wallet.Account
+		channelsMutex   sync.Mutex
+		channels        map[channel.ID]*PaymentChannel // open channels
+		onNew           NewChannelCallback             // nil if not set
+		restoring       func(*PaymentChannel)          // set during Restore
+		outbox          *outbox                        // nil if not enabled
+		onWatcherFailed WatcherFailureHandler          // nil if not set
 
-		dialer *simple.Dialer
-		bus    *net.Bus
+		discoveryMutex sync.Mutex
+		responder      *mdns.Responder    // nil if not advertising
+		stopBrowsing   context.CancelFunc // nil if not discovering
+		// addedPeers are the peers that were added with AddPeer. Discovery
+		// does not change their addresses.
+		addedPeers map[wallet.AddrKey]struct{}
 	}
 
 	// NewChannelCallback wraps a `func(*PaymentChannel)`
@@ -59,68 +88,114 @@ type (
 // NewClient sets up a new Client with configuration `cfg`.
 // The Client:
 //  - imports the keystore and unlocks the account
-//  - listens on IP:port
-//  - connects to the eth node
+//  - listens on IP:port with the configured Transport
+//  - connects to the eth nodes and fails over between them
 //  - in case either the Adjudicator and AssetHolder of the `cfg` are nil, it
-//    deploys needed contract. There is currently no check that the
-//    correct bytecode is deployed to the given addresses if they are
-//    not nil.
+//    deploys needed contract. The bytecode at the given addresses is
+//    checked before a channel is proposed or accepted.
 //  - sets the `cfg`s Adjudicator and AssetHolder to the deployed contracts
 //    addresses in case they were deployed.
-func NewClient(ctx *Context, cfg *Config, w *Wallet) (*Client, error) {
-	endpoint := fmt.Sprintf("%s:%d", cfg.IP, cfg.Port)
-	listener, err := simple.NewTCPListener(endpoint)
+func NewClient(ctx *Context, cfg *Config, w *Wallet) (_ *Client, err error) {
+	defer func() { err = toError(err) }()
+	acc, err := w.unlock(*cfg.Address)
+	if err != nil {
+		return nil, errors.WithMessage(err, "finding account")
+	}
+
+	listener, dialer, err := newTransport(cfg, w, acc.Account)
 	if err != nil {
-		return nil, errors.WithMessagef(err, "listening on %s", endpoint)
+		return nil, errors.WithMessage(err, "setting up transport")
 	}
-	dialer := simple.NewTCPDialer(time.Second * 15)
-	ethClient, err := ethclient.Dial(cfg.ETHNodeURL)
+	closed := make(chan struct{})
+	defer func() {
+		if err != nil {
+			// Stops the goroutines of the ETH nodes and transactions.
+			close(closed)
+			listener.Close()
+			dialer.Close()
+		}
+	}()
+	urls := append([]string{cfg.ETHNodeURL}, splitURLs(cfg.FallbackETHNodeURLs)...)
+	pollInterval := time.Duration(cfg.PollInterval) * time.Millisecond
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	ethClient, err := dialETHNodes(ctx.ctx, urls, pollInterval, closed)
 	if err != nil {
 		return nil, errors.WithMessage(err, "connecting to ethereum node")
 	}
 
-	acc, err := w.unlock(*cfg.Address)
+	chainID, err := ethClient.ChainID(ctx.ctx)
 	if err != nil {
-		return nil, errors.WithMessage(err, "finding account")
+		return nil, errors.WithMessage(err, "querying chain ID")
 	}
-
-	signer := types.NewEIP155Signer(big.NewInt(1337))
-	cb := ethchannel.NewContractBackend(ethClient, keystore.NewTransactor(*w.w, signer), cfg.TxFinalityDepth)
+	signer := types.NewEIP155Signer(chainID)
+	tr := keystore.NewTransactor(*w.w, signer)
+	nonces := newNonceManager(ethClient, tr, acc.Account, signer)
+	go nonces.run(closed)
+	txs := newTxTracker(nonces, cfg.TxFinalityDepth, closed)
+	cb := ethchannel.NewContractBackend(txs, tr, cfg.TxFinalityDepth)
 	if err := setupContracts(ctx.ctx, cb, acc.Account, cfg); err != nil {
 		return nil, errors.WithMessage(err, "setting up contracts")
 	}
 
-	bus := net.NewBus(acc, dialer)
-	adjudicator := ethchannel.NewAdjudicator(cb, common.Address(cfg.Adjudicator.addr), acc.Account.Address, acc.Account)
+	c := &Client{cfg: cfg, ethClient: ethClient, cb: cb,
+		persister: nil,
+		wallet:    w.w,
+		onChain:   acc,
+		dialer:    dialer,
+		nonces:    nonces,
+		txs:       txs,
+		closed:    closed,
+		channels:  make(map[channel.ID]*PaymentChannel),
+	}
+	txs.setEvents(&c.events)
+	c.monitor = newConnMonitor(c.deliverTo, c.reconnect)
+	c.bus = net.NewBus(acc, &monitoredDialer{Dialer: dialer, m: c.monitor})
+	c.adjudicator = newReceiverAdjudicator(cb, common.Address(cfg.Adjudicator.addr), acc.Account)
 	depositor := new(ethchannel.ETHDepositor)
 
 	funder := ethchannel.NewFunder(cb)
 	if !funder.RegisterAsset(cfg.AssetHolder.addr, depositor, acc.Account) {
 		return nil, errors.New("Could not register asset")
 	}
-	watcher, err := local.NewWatcher(adjudicator)
+	localWatcher, err := local.NewWatcher(c.adjudicator)
 	if err != nil {
 		return nil, errors.WithMessage(err, "creating watcher")
 	}
-	c, err := client.New(acc.Address(), bus, funder, adjudicator, w.w, watcher)
-	if err != nil {
+	var chWatcher watcher.Watcher = localWatcher
+	if cfg.WatchtowerURL != "" {
+		c.tower = watchtower.NewClient(cfg.WatchtowerURL)
+		chWatcher = &towerWatcher{Watcher: localWatcher, tower: c.tower, adjudicator: common.Address(cfg.Adjudicator.addr)}
+	}
+	fundingTimeout := time.Duration(cfg.FundingTimeout) * time.Second
+	timedFunder := &timeoutFunder{Funder: funder, timeout: fundingTimeout, closed: closed}
+	if c.client, err = client.New(acc.Address(), c.bus, timedFunder, c.adjudicator, w.w, chWatcher); err != nil {
 		return nil, errors.WithMessage(err, "creating client")
 	}
-	go bus.Listen(listener)
+	c.client.OnNewChannel(c.onNewChannel)
+	go c.bus.Listen(&monitoredListener{Listener: listener, m: c.monitor})
 
-	return &Client{cfg: cfg, ethClient: ethClient,
-		client:    c,
-		persister: nil,
-		wallet:    w.w,
-		onChain:   acc,
-		dialer:    dialer,
-		bus:       bus}, nil
+	return c, nil
 }
 
 // Close closes the client and its PersistRestorer to synchronize the database.
+// Repeated calls return an error.
 // ref https://pkg.go.dev/perun.network/go-perun/client?tab=doc#Channel.Close
 // ref https://pkg.go.dev/perun.network/go-perun/channel/persistence/keyvalue?tab=doc#PersistRestorer.Close
 func (c *Client) Close() error {
+	err := errors.New("client already closed")
+	c.closeOnce.Do(func() { err = c.close() })
+	return err
+}
+
+// close closes the Client, it must only be called once.
+func (c *Client) close() error {
+	close(c.closed)
+	c.StopDiscovery()
+	if c.tower != nil {
+		c.tower.Close()
+	}
 	if err := c.client.Close(); err != nil {
 		return errors.WithMessage(err, "closing client")
 	}
@@ -138,19 +213,95 @@ func (c *Client) Close() error {
 // Incoming proposals and updates are forwarded to the passed handlers.
 // ref https://pkg.go.dev/perun.network/go-perun/client?tab=doc#Client.Handle
 func (c *Client) Handle(ph ProposalHandler, uh UpdateHandler) {
-	c.client.Handle(&proposalHandler{c: c, h: ph}, &updateHandler{h: uh})
+	c.client.Handle(&proposalHandler{c: c, h: ph}, &updateHandler{c: c, h: uh})
 }
 
 // OnNewChannel sets a handler to be called whenever a new channel is created
 // or restored. Only one such handler can be set at a time, and repeated calls
 // to this function will overwrite the currently existing handler. This
 // function may be safely called at any time.
-// Start the watcher routine here, if needed.
+// The channels passed to `callback` are already watched for disputes.
 // ref https://pkg.go.dev/perun.network/go-perun/client?tab=doc#Client.OnNewChannel
 func (c *Client) OnNewChannel(callback NewChannelCallback) {
-	c.client.OnNewChannel(func(ch *client.Channel) {
-		callback.OnNew(&PaymentChannel{ch})
-	})
+	c.channelsMutex.Lock()
+	defer c.channelsMutex.Unlock()
+	c.onNew = callback
+}
+
+// onNewChannel tracks a new or restored channel and passes it to the
+// NewChannelCallback.
+func (c *Client) onNewChannel(ch *client.Channel) {
+	pch := c.paymentChannel(ch)
+	c.channelsMutex.Lock()
+	callback, restoring, o := c.onNew, c.restoring, c.outbox
+	c.channelsMutex.Unlock()
+	if restoring != nil {
+		restoring(pch)
+	}
+	if o != nil {
+		o.deliver(pch)
+	}
+	if callback != nil {
+		callback.OnNew(pch)
+	}
+}
+
+// setRestoring sets a function that is called for every restored channel.
+func (c *Client) setRestoring(restoring func(*PaymentChannel)) {
+	c.channelsMutex.Lock()
+	defer c.channelsMutex.Unlock()
+	c.restoring = restoring
+}
+
+// trackedChannel returns the tracked PaymentChannel with ID `id` or nil.
+func (c *Client) trackedChannel(id channel.ID) *PaymentChannel {
+	c.channelsMutex.Lock()
+	defer c.channelsMutex.Unlock()
+	return c.channels[id]
+}
+
+// paymentChannel returns the tracked PaymentChannel of `ch` and starts
+// tracking it if it is new.
+func (c *Client) paymentChannel(ch *client.Channel) *PaymentChannel {
+	c.channelsMutex.Lock()
+	if pch, ok := c.channels[ch.ID()]; ok {
+		c.channelsMutex.Unlock()
+		return pch
+	}
+	pch := &PaymentChannel{ch: ch, c: c, watcher: newChannelWatcher(&c.events)}
+	c.channels[ch.ID()] = pch
+	c.channelsMutex.Unlock()
+	go c.supervise(pch)
+	go c.resumeForceClose(pch)
+	c.events.emitState(EventChannelOpened, ch.State())
+	return pch
+}
+
+// removeChannel stops tracking and supervising the channel `id` and drops
+// its queued payments.
+func (c *Client) removeChannel(id channel.ID) {
+	c.channelsMutex.Lock()
+	pch, ok := c.channels[id]
+	delete(c.channels, id)
+	c.channelsMutex.Unlock()
+	if ok {
+		pch.watcher.stop()
+	}
+	c.dropPayments(id)
+}
+
+// hasChannelWith returns whether there is an open channel with `peer`.
+func (c *Client) hasChannelWith(peer wire.Address) bool {
+	c.channelsMutex.Lock()
+	defer c.channelsMutex.Unlock()
+	for _, pch := range c.channels {
+		for _, p := range pch.ch.Peers() {
+			if p.Equal(peer) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // EnablePersistence loads or creates a levelDB database at the given `dbPath`
@@ -164,30 +315,27 @@ func (c *Client) EnablePersistence(dbPath string) (err error) {
 
 	db, err = leveldb.LoadDatabase(dbPath)
 	if err != nil {
-		return errors.WithMessage(err, "creating/loading database")
+		return newError(ErrorPersistence, "", errors.WithMessage(err, "creating/loading database"))
 	}
+	c.db = db
+	c.adjudicator.enablePersistence(db)
 	c.persister = keyvalue.NewPersistRestorer(db)
 	c.client.EnablePersistence(c.persister)
 	return nil
 }
 
-// Restore restores all channels from persistence. Channels are restored in
-// parallel. Newly restored channels should be acquired through the
-// OnNewChannel callback.
-// Note that connections are currently established serially, so allow for
-// enough time in the passed context.
-// ref https://pkg.go.dev/perun.network/go-perun/client?tab=doc#Client.Restore
-func (c *Client) Restore(ctx *Context) error {
-	if c.persister == nil {
-		return errors.New("persistence not enabled")
-	}
-	return c.client.Restore(ctx.ctx)
-}
-
 // AddPeer adds a new peer to the client. Must be called before proposing
-// a new channel with said peer. Wraps go-perun/peer/net/Dialer.Register.
+// a new channel with said peer. The peer must use the same Transport.
+// With TransportRelay, peers are reached by their `perunID` alone.
+// Wraps go-perun/peer/net/Dialer.Register.
 // ref https://pkg.go.dev/perun.network/go-perun/peer/net?tab=doc#Dialer.Register
 func (c *Client) AddPeer(perunID *Address, host string, port int) {
+	c.discoveryMutex.Lock()
+	defer c.discoveryMutex.Unlock()
+	if c.addedPeers == nil {
+		c.addedPeers = make(map[wallet.AddrKey]struct{})
+	}
+	c.addedPeers[wallet.Key(&perunID.addr)] = struct{}{}
 	c.dialer.Register((*ethwallet.Address)(&perunID.addr), fmt.Sprintf("%s:%d", host, port))
 }
 
@@ -217,5 +365,8 @@ func setupContracts(ctx context.Context, cb ethchannel.ContractBackend, deployer
 // OnChainBalance returns the on-chain balance for `address` in Wei.
 func (c *Client) OnChainBalance(ctx *Context, address *Address) (*BigInt, error) {
 	bal, err := c.ethClient.BalanceAt(ctx.ctx, common.Address(address.addr), nil)
-	return &BigInt{bal}, err
+	if err != nil {
+		return nil, toError(errors.WithMessage(err, "querying balance"))
+	}
+	return &BigInt{bal}, nil
 }
@@ -6,12 +6,16 @@
 package prnm
 
 import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/pkg/errors"
 
 	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
 	"perun.network/go-perun/channel"
 	"perun.network/go-perun/client"
 	"perun.network/go-perun/log"
+	"perun.network/go-perun/wallet"
 	"perun.network/go-perun/wire"
 )
 
@@ -21,39 +25,113 @@ import (
 // channel controller if the channel was successfully created and funded.
 //
 // After the channel got successfully created, the user is required to start the
-// update handler with PaymentChannel.HandleUpdates(UpdateHandler) and to start
-// the channel watcher with PaymentChannel.Watch() on the returned channel
-// controller.
+// update handler with PaymentChannel.HandleUpdates(UpdateHandler) on the
+// returned channel controller. The Client watches the channel on-chain by
+// itself; its events are passed to the EventListener.
+//
+// The peer must respond within Config.ProposalTimeout, or an
+// ErrorPeerUnreachable is returned. The funding is bounded by
+// Config.FundingTimeout instead of the passed context and fails with an
+// ErrorFundingTimeout when it expires. The challenge duration must be within
+// Config.MinChallengeDuration and Config.MaxChallengeDuration and
+// initialBals must hold two non-negative balances, or an ErrorInvalidArgument
+// is returned. See ClientError.
 //
-// It is important that the passed context does not cancel before twice the
-// ChallengeDuration has passed (at least for real blockchain backends with wall
-// time), or the channel cannot be settled if a peer times out funding.
+// Before the proposal is sent, it is checked that the contracts are valid,
+// the peer is reachable and our on-chain balance covers our deposit plus the
+// fees of the deposit transaction. Otherwise a ClientError with code
+// ErrorInvalidContracts, ErrorPeerUnreachable or ErrorInsufficientFunds is
+// returned.
 //
 // The remote peer must have been added to the Client via AddPeer prior
 // to the call to ProposeChannel. Should the connected peer have a different
-// `perunID` than the one given in AddPeer, an error in the form of
-// "Dialed impersonator" will be thrown.
+// `perunID` than the one given in AddPeer, a ClientError with code
+// ErrorPeerImpersonated is returned.
 func (c *Client) ProposeChannel(
 	ctx *Context,
 	perunID *Address,
 	challengeDuration int64,
 	initialBals *BigInts,
 ) (*PaymentChannel, error) {
+	return c.proposeChannel(ctx, perunID, challengeDuration, initialBals, nil)
+}
+
+// ProposeChannelWithReceiver proposes a channel like ProposeChannel, but
+// withdraws our funds to `receiver` when the channel is settled.
+// See PaymentChannel.SetWithdrawalReceiver.
+func (c *Client) ProposeChannelWithReceiver(
+	ctx *Context,
+	perunID *Address,
+	challengeDuration int64,
+	initialBals *BigInts,
+	receiver *Address,
+) (*PaymentChannel, error) {
+	addr := common.Address(receiver.addr)
+	if err := c.validateReceiver(ctx.ctx, addr); err != nil {
+		return nil, toError(err)
+	}
+	return c.proposeChannel(ctx, perunID, challengeDuration, initialBals, &addr)
+}
+
+// proposeChannel proposes a channel. If `receiver` is not nil, it is stored
+// as withdrawal receiver before the proposal is sent, so that a failure
+// cannot leave an opened channel without its receiver.
+func (c *Client) proposeChannel(
+	ctx *Context,
+	perunID *Address,
+	challengeDuration int64,
+	initialBals *BigInts,
+	receiver *common.Address,
+) (*PaymentChannel, error) {
+	if err := c.checkChallengeDuration(challengeDuration); err != nil {
+		return nil, toError(err)
+	}
+	if err := checkBalances(initialBals); err != nil {
+		return nil, newError(ErrorInvalidArgument, "", err)
+	}
+	peer := (*ethwallet.Address)(&perunID.addr)
+	if err := c.preflight(ctx.ctx, peer, initialBals.values[0]); err != nil {
+		return nil, err
+	}
+	part, err := c.newParticipant(receiver)
+	if err != nil {
+		return nil, err
+	}
 	alloc := &channel.Allocation{
 		Assets:   []channel.Asset{(*ethwallet.Address)(&c.cfg.AssetHolder.addr)},
 		Balances: [][]channel.Bal{initialBals.values},
 	}
 	prop, err := client.NewLedgerChannelProposal(
 		uint64(challengeDuration),
-		c.wallet.NewAccount().Address(),
+		part,
 		alloc,
-		[]wire.Address{c.onChain.Address(), (*ethwallet.Address)(&perunID.addr)},
+		[]wire.Address{c.onChain.Address(), peer},
 		client.WithoutApp())
 	if err != nil {
-		return nil, err
+		return nil, toError(err)
 	}
-	_ch, err := c.client.ProposeChannel(ctx.ctx, prop)
-	return &PaymentChannel{_ch}, err
+	pctx, cancel := c.withProposalTimeout(ctx.ctx)
+	defer cancel()
+	_ch, err := c.client.ProposeChannel(pctx, prop)
+	if err != nil {
+		return nil, toError(c.proposalError(ctx.ctx, pctx, err))
+	}
+	pch := c.paymentChannel(_ch)
+	c.events.emitState(EventChannelFunded, _ch.State())
+	return pch, nil
+}
+
+// newParticipant generates the account that we use as participant of a new
+// channel. If `receiver` is not nil, it is stored as withdrawal receiver of
+// the channel.
+func (c *Client) newParticipant(receiver *common.Address) (wallet.Address, error) {
+	part := c.wallet.NewAccount().Address()
+	if receiver != nil {
+		if err := c.adjudicator.setPartReceiver(part, *receiver); err != nil {
+			return nil, newError(ErrorPersistence, "", err)
+		}
+	}
+	return part, nil
 }
 
 type (
@@ -106,6 +184,14 @@ func (h *proposalHandler) HandleProposal(_prop client.ChannelProposal, _resp *cl
 		log.Warn("Ignored proposal: ", err)
 		return
 	}
+	if err := h.c.checkChallengeDuration(int64(ledgerProp.ChallengeDuration)); err != nil {
+		ctx, cancel := h.c.withProposalTimeout(context.Background())
+		defer cancel()
+		if err := _resp.Reject(ctx, err.Error()); err != nil {
+			log.WithError(err).Warn("Rejecting proposal")
+		}
+		return
+	}
 	// Security Note: we don't check the remote nonce or channel participant. If
 	// this code were to evolve to production grade, this needs to be taken care
 	// of. In this case, at least the Nonce should be part of the ChannelProposal
@@ -124,26 +210,75 @@ func (h *proposalHandler) HandleProposal(_prop client.ChannelProposal, _resp *cl
 // created and funded. Panics if the proposal was already accepted or rejected.
 //
 // After the channel got successfully created, the user is required to start the
-// update handler with PaymentChannel.HandleUpdates(UpdateHandler) and to start
-// the channel watcher with PaymentChannel.Watch() on the returned channel
-// controller.
+// update handler with PaymentChannel.HandleUpdates(UpdateHandler) on the
+// returned channel controller. The Client watches the channel on-chain by
+// itself; its events are passed to the EventListener.
 //
-// It is important that the passed context does not cancel before twice the
-// ChallengeDuration has passed (at least for real blockchain backends with wall
-// time), or the channel cannot be settled if a peer times out funding.
+// The peer must complete the message exchange within Config.ProposalTimeout,
+// or an ErrorPeerUnreachable is returned. The funding is bounded by
+// Config.FundingTimeout instead of the passed context and fails with an
+// ErrorFundingTimeout when it expires. See ClientError.
+//
+// Before the proposal is accepted, the same checks as in ProposeChannel are
+// done. If they fail, the proposal is neither accepted nor rejected, so that
+// Reject can still be called.
 func (r *ProposalResponder) Accept(ctx *Context) (*PaymentChannel, error) {
+	return r.accept(ctx, nil)
+}
+
+// AcceptWithReceiver accepts the channel proposal like Accept, but withdraws
+// our funds to `receiver` when the channel is settled.
+// See PaymentChannel.SetWithdrawalReceiver.
+func (r *ProposalResponder) AcceptWithReceiver(ctx *Context, receiver *Address) (*PaymentChannel, error) {
+	addr := common.Address(receiver.addr)
+	if err := r.c.validateReceiver(ctx.ctx, addr); err != nil {
+		return nil, toError(err)
+	}
+	return r.accept(ctx, &addr)
+}
+
+// accept accepts the channel proposal. If `receiver` is not nil, it is
+// stored as withdrawal receiver before the proposal is accepted.
+func (r *ProposalResponder) accept(ctx *Context, receiver *common.Address) (*PaymentChannel, error) {
+	if err := r.c.preflight(ctx.ctx, r.p.Peers[0], r.p.InitBals.Balances[0][1]); err != nil {
+		return nil, err
+	}
 	// Generate new account as channel participant.
-	account := r.c.wallet.NewAccount().Address()
+	account, err := r.c.newParticipant(receiver)
+	if err != nil {
+		return nil, err
+	}
 	acceptor := r.p.Accept(account, client.WithRandomNonce())
-	ch, err := r.r.Accept(ctx.ctx, acceptor)
-	return &PaymentChannel{ch}, err
+	pctx, cancel := r.c.withProposalTimeout(ctx.ctx)
+	defer cancel()
+	ch, err := r.r.Accept(pctx, acceptor)
+	if err != nil {
+		return nil, toError(r.c.proposalError(ctx.ctx, pctx, err))
+	}
+	pch := r.c.paymentChannel(ch)
+	r.c.events.emitState(EventChannelFunded, ch.State())
+	return pch, nil
 }
 
 // Reject lets the user signal that they reject the channel proposal.
 // Returns whether the rejection message was successfully sent. Panics if the
 // proposal was already accepted or rejected.
 func (r *ProposalResponder) Reject(ctx *Context, reason string) error {
-	return r.r.Reject(ctx.ctx, reason)
+	return toError(r.r.Reject(ctx.ctx, reason))
+}
+
+// checkBalances checks that `bals` contains a non-negative balance for both
+// participants.
+func checkBalances(bals *BigInts) error {
+	if bals == nil || len(bals.values) != 2 {
+		return errors.New("initial balances must contain two entries")
+	}
+	for _, bal := range bals.values {
+		if bal == nil || bal.Sign() < 0 {
+			return errors.New("initial balances must be set and not negative")
+		}
+	}
+	return nil
 }
 
 func checkProp(prop client.LedgerChannelProposal) error {
@@ -0,0 +1,38 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Command prnm-relay runs a relay server that forwards wire connections
+// between prnm clients which use the relay transport.
+package main
+
+import (
+	"flag"
+	"net"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/perun-network/perun-eth-mobile/relay"
+	"perun.network/go-perun/log"
+	plogrus "perun.network/go-perun/log/logrus"
+)
+
+func main() {
+	addr := flag.String("listen", "0.0.0.0:5760", "Address to accept connections on")
+	verbose := flag.Bool("v", false, "Log debug messages")
+	flag.Parse()
+
+	logger := logrus.New()
+	if *verbose {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+	log.Set(plogrus.FromLogrus(logger))
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		logger.WithError(err).Fatal("Listening")
+	}
+	logger.Infof("Relay listening on %s", l.Addr())
+	logger.WithError(relay.NewServer().Serve(l)).Fatal("Relay stopped")
+}
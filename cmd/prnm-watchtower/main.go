@@ -0,0 +1,94 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Command prnm-watchtower runs a watchtower that prnm clients submit their
+// channel states to. It refutes outdated states that are registered on-chain
+// and pays the transaction fees from its own account.
+package main
+
+import (
+	"flag"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	ethkeystore "github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/sirupsen/logrus"
+
+	"github.com/perun-network/perun-eth-mobile/watchtower"
+	_ "perun.network/go-perun/backend/ethereum" // backend init
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/backend/ethereum/wallet/keystore"
+	"perun.network/go-perun/log"
+	plogrus "perun.network/go-perun/log/logrus"
+)
+
+func main() {
+	addr := flag.String("listen", "0.0.0.0:5770", "Address to accept submissions on")
+	ethURL := flag.String("eth-url", "ws://127.0.0.1:8545", "URL of the ETH node")
+	adjudicator := flag.String("adjudicator", "", "Address of the Adjudicator whose channels are watched")
+	secret := flag.String("secret", "", "Hex encoded secret key of the account that pays for transactions")
+	keyDir := flag.String("keystore", "watchtower-keys", "Directory of the keystore")
+	password := flag.String("password", "", "Password of the keystore")
+	dbDir := flag.String("db", "watchtower-db", "Directory to store submissions in")
+	chainID := flag.Int64("chain-id", 1337, "Chain ID of the ETH network")
+	finality := flag.Uint64("finality-depth", 1, "Number of blocks after which a transaction is final")
+	verbose := flag.Bool("v", false, "Log debug messages")
+	flag.Parse()
+
+	logger := logrus.New()
+	if *verbose {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+	log.Set(plogrus.FromLogrus(logger))
+
+	if !common.IsHexAddress(*adjudicator) {
+		logger.Fatal("Missing or invalid adjudicator address")
+	}
+	sk, err := crypto.HexToECDSA(strings.TrimPrefix(*secret, "0x"))
+	if err != nil {
+		logger.WithError(err).Fatal("Decoding secret key")
+	}
+	ks := ethkeystore.NewKeyStore(*keyDir, ethkeystore.LightScryptN, ethkeystore.LightScryptP)
+	ethAcc, err := ks.Find(accounts.Account{Address: crypto.PubkeyToAddress(sk.PublicKey)})
+	if err != nil {
+		if ethAcc, err = ks.ImportECDSA(sk, *password); err != nil {
+			logger.WithError(err).Fatal("Importing secret key")
+		}
+	}
+	w, err := keystore.NewWallet(ks, *password)
+	if err != nil {
+		logger.WithError(err).Fatal("Creating wallet")
+	}
+	acc, err := w.Unlock(ethwallet.AsWalletAddr(ethAcc.Address))
+	if err != nil {
+		logger.WithError(err).Fatal("Unlocking account")
+	}
+
+	ethClient, err := ethclient.Dial(*ethURL)
+	if err != nil {
+		logger.WithError(err).Fatal("Connecting to ETH node")
+	}
+	signer := types.NewEIP155Signer(big.NewInt(*chainID))
+	cb := ethchannel.NewContractBackend(ethClient, keystore.NewTransactor(*w, signer), *finality)
+	tower, err := watchtower.NewServer(cb, acc.(*keystore.Account), common.HexToAddress(*adjudicator), *dbDir)
+	if err != nil {
+		logger.WithError(err).Fatal("Starting watchtower")
+	}
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		logger.WithError(err).Fatal("Listening")
+	}
+	logger.Infof("Watchtower of %s listening on %s, watching %d channels", ethAcc.Address.Hex(), l.Addr(), tower.NumChannels())
+	logger.WithError(http.Serve(l, tower)).Fatal("Watchtower stopped")
+}
@@ -24,9 +24,56 @@ type Config struct {
 	ETHNodeURL               string // URL of the ETH node. Example: ws://127.0.0.1:8545
 	IP                       string // Ip to listen on.
 	Port                     uint16 // Port to listen on.
+	// FallbackETHNodeURLs is a comma separated list of URLs of further ETH
+	// nodes of the same chain. They are used in this order whenever the
+	// preceding nodes are unreachable. Optional.
+	FallbackETHNodeURLs string
+	// PollInterval is the interval in milliseconds in which ETH nodes with
+	// http:// or https:// URLs are polled for new blocks and logs, since
+	// they do not support subscriptions. Defaults to 2000 if not positive.
+	PollInterval int
 	// TxFinalityDepth how many blocks a Transaction needs to be included
 	// in to be considered final.
 	TxFinalityDepth uint64
+	// Transport selects how peers are connected on the wire. One of
+	// TransportTCP (default), TransportTLS, TransportWebSocket,
+	// TransportWebSocketTLS or TransportRelay. Both peers of a channel must
+	// use the same transport.
+	Transport string
+	// RelayAddress is the host:port of the relay server that is used by
+	// TransportRelay. IP and Port are not used in this case. Relayed
+	// sessions are secured by TLS with certificates pinned to Perun IDs, so
+	// the TLS files below must not be set.
+	RelayAddress string
+	// PEM encoded certificate and key to serve TLS connections with.
+	// If both are empty, a self-signed certificate bound to the Perun ID is
+	// generated and peers are pinned to their Perun IDs instead.
+	TLSCertFile, TLSKeyFile string
+	// TLSRootCAFile is a PEM file with the root CAs that peer certificates
+	// are verified against. The system's root CAs are used if empty.
+	TLSRootCAFile string
+	// RestoreWorkers is the number of peers that Client.Restore connects to
+	// concurrently. Defaults to 4 if not positive.
+	RestoreWorkers int
+	// ProposalTimeout is the time in seconds that ProposeChannel and
+	// ProposalResponder.Accept wait for the messages of the peer, excluding
+	// the funding. Defaults to 30 if not positive.
+	ProposalTimeout int64
+	// FundingTimeout is the time in seconds that the funding of a new
+	// channel may take. Defaults to twice the challenge duration of the
+	// channel if not positive.
+	FundingTimeout int64
+	// MinChallengeDuration and MaxChallengeDuration bound the challenge
+	// duration in seconds of outgoing and incoming channel proposals. A
+	// bound is not enforced if it is not positive.
+	MinChallengeDuration, MaxChallengeDuration int64
+	// Tokens are the ERC-20 tokens that Client.TransferToken can transfer.
+	// Optional.
+	Tokens *Addresses
+	// WatchtowerURL is the URL of a watchtower, e.g. http://10.5.0.12:5770,
+	// that every signed channel state is submitted to. The watchtower
+	// refutes outdated states while the Client is offline. Not used if empty.
+	WatchtowerURL string
 }
 
 // NewConfig creates a new configuration.
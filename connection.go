@@ -0,0 +1,353 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire"
+	wirenet "perun.network/go-perun/wire/net"
+)
+
+// Connection states of a peer as returned by Client.PeerStatus.
+const (
+	// PeerUnknown means that there was never a connection to the peer.
+	PeerUnknown = iota
+	// PeerConnected means that there is an authenticated connection.
+	PeerConnected
+	// PeerDisconnected means that the connection to the peer was lost.
+	PeerDisconnected
+	// PeerReconnecting means that the connection was lost and the Client
+	// tries to reconnect since there are open channels with the peer.
+	PeerReconnecting
+)
+
+const (
+	// minReconnectBackoff and maxReconnectBackoff bound the waiting time
+	// between reconnection attempts.
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = 2 * time.Minute
+)
+
+type (
+	// ConnectionHandler is notified about changes of the wire connections to
+	// peers. The notifications are delivered one after another in the order
+	// of the changes, so the handler should return quickly.
+	ConnectionHandler interface {
+		OnPeerConnected(peer *Address)
+		OnPeerDisconnected(peer *Address)
+		// OnPeerReconnecting is called before each reconnection attempt,
+		// starting with attempt 1.
+		OnPeerReconnecting(peer *Address, attempt int)
+	}
+
+	// connMonitor tracks the connection state of peers by observing the
+	// connections of the wire bus.
+	connMonitor struct {
+		mutex   sync.Mutex
+		peers   map[wallet.AddrKey]*peerConn
+		handler ConnectionHandler
+		// queue holds the pending notifications of the handler, which are
+		// delivered in order by a single dispatching routine.
+		queue       []func()
+		dispatching bool
+		// onConnected is called when a peer becomes connected.
+		onConnected func(peer wire.Address)
+		// onLost is called when the last connection to a peer is lost.
+		onLost func(peer wire.Address)
+	}
+
+	// peerConn is the connection state of a peer.
+	peerConn struct {
+		conns  int // number of authenticated connections
+		status int
+	}
+
+	// monitoredDialer reports the connections of a wirenet.Dialer.
+	monitoredDialer struct {
+		wirenet.Dialer
+		m *connMonitor
+	}
+
+	// monitoredListener reports the connections of a wirenet.Listener.
+	monitoredListener struct {
+		wirenet.Listener
+		m *connMonitor
+	}
+
+	// monitoredConn reports a connection as established once the first
+	// message was received, which is the authentication message of the peer,
+	// and as lost once it fails or is closed.
+	monitoredConn struct {
+		wirenet.Conn
+		m      *connMonitor
+		dialed wire.Address // nil for accepted connections
+
+		mutex sync.Mutex
+		peer  wire.Address // nil until the first message was received
+		lost  bool
+	}
+
+	// authenticatedConn is a connection whose transport authenticates the
+	// Perun ID of the peer, like pinned TLS.
+	authenticatedConn interface {
+		PeerID() (*common.Address, error)
+	}
+)
+
+// newConnMonitor returns a connMonitor that calls `onConnected` whenever a
+// peer becomes connected and `onLost` whenever the last connection to a peer
+// is lost.
+func newConnMonitor(onConnected, onLost func(peer wire.Address)) *connMonitor {
+	return &connMonitor{
+		peers:       make(map[wallet.AddrKey]*peerConn),
+		onConnected: onConnected,
+		onLost:      onLost,
+	}
+}
+
+// SetConnectionHandler sets a handler to be notified about connection changes
+// of peers. Only one such handler can be set at a time, and repeated calls to
+// this function will overwrite the currently existing handler. This function
+// may be safely called at any time.
+func (c *Client) SetConnectionHandler(h ConnectionHandler) {
+	c.monitor.mutex.Lock()
+	defer c.monitor.mutex.Unlock()
+	c.monitor.handler = h
+}
+
+// PeerStatus returns the connection state of the peer `perunID`, one of
+// PeerUnknown, PeerConnected, PeerDisconnected or PeerReconnecting.
+func (c *Client) PeerStatus(perunID *Address) int {
+	return c.monitor.status((*ethwallet.Address)(&perunID.addr))
+}
+
+// reconnect tries to connect to `peer` with exponential backoff as long as
+// there are open channels with it.
+func (c *Client) reconnect(peer wire.Address) {
+	if !c.hasChannelWith(peer) || !c.monitor.setStatus(peer, PeerReconnecting, PeerDisconnected) {
+		return
+	}
+	defer c.monitor.setStatus(peer, PeerDisconnected, PeerReconnecting)
+	backoff := minReconnectBackoff
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-c.closed:
+			return
+		case <-time.After(backoff):
+		}
+		if !c.hasChannelWith(peer) || !c.monitor.reconnecting(peer, attempt) {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+		err := c.connect(ctx, peer)
+		cancel()
+		if err == nil {
+			return
+		}
+		log.WithError(err).WithField("peer", peer).Debug("Reconnecting")
+		if backoff *= 2; backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// connect establishes a connection to `peer`, if there is none, by sending a
+// ping over the bus.
+func (c *Client) connect(ctx context.Context, peer wire.Address) error {
+	return c.bus.Publish(ctx, &wire.Envelope{
+		Sender:    c.onChain.Address(),
+		Recipient: peer,
+		Msg:       wire.NewPingMsg(),
+	})
+}
+
+// status returns the connection state of `peer`.
+func (m *connMonitor) status(peer wire.Address) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if p, ok := m.peers[wallet.Key(peer)]; ok {
+		return p.status
+	}
+	return PeerUnknown
+}
+
+// setStatus sets the state of `peer` to `status` if it currently is `from`
+// and returns whether it did so.
+func (m *connMonitor) setStatus(peer wire.Address, status, from int) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	p, ok := m.peers[wallet.Key(peer)]
+	if !ok || p.status != from {
+		return false
+	}
+	p.status = status
+	return true
+}
+
+// connected records a new authenticated connection to `peer`.
+func (m *connMonitor) connected(peer wire.Address) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	p, ok := m.peers[wallet.Key(peer)]
+	if !ok {
+		p = new(peerConn)
+		m.peers[wallet.Key(peer)] = p
+	}
+	p.conns++
+	if p.status != PeerConnected {
+		p.status = PeerConnected
+		if h := m.handler; h != nil {
+			m.notify(func() { h.OnPeerConnected(toAddress(peer)) })
+		}
+		go m.onConnected(peer)
+	}
+}
+
+// disconnected records that a connection to `peer` was lost.
+func (m *connMonitor) disconnected(peer wire.Address) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	p := m.peers[wallet.Key(peer)]
+	if p.conns--; p.conns > 0 {
+		return
+	}
+	p.status = PeerDisconnected
+	if h := m.handler; h != nil {
+		m.notify(func() { h.OnPeerDisconnected(toAddress(peer)) })
+	}
+	go m.onLost(peer)
+}
+
+// reconnecting records a reconnection attempt to `peer`. It returns false
+// and records nothing if the peer is not reconnecting anymore, e.g. because
+// it connected to us in the meantime.
+func (m *connMonitor) reconnecting(peer wire.Address, attempt int) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if p, ok := m.peers[wallet.Key(peer)]; !ok || p.status != PeerReconnecting {
+		return false
+	}
+	if h := m.handler; h != nil {
+		m.notify(func() { h.OnPeerReconnecting(toAddress(peer), attempt) })
+	}
+	return true
+}
+
+// notify queues the handler notification `f` and starts the dispatching
+// routine if it is not running. The mutex must be held.
+func (m *connMonitor) notify(f func()) {
+	m.queue = append(m.queue, f)
+	if !m.dispatching {
+		m.dispatching = true
+		go m.dispatch()
+	}
+}
+
+// dispatch delivers the queued notifications in order until the queue is
+// empty.
+func (m *connMonitor) dispatch() {
+	for {
+		m.mutex.Lock()
+		if len(m.queue) == 0 {
+			m.dispatching = false
+			m.mutex.Unlock()
+			return
+		}
+		f := m.queue[0]
+		m.queue[0] = nil
+		m.queue = m.queue[1:]
+		m.mutex.Unlock()
+		f()
+	}
+}
+
+// Dial implements wirenet.Dialer.Dial.
+func (d *monitoredDialer) Dial(ctx context.Context, addr wire.Address) (wirenet.Conn, error) {
+	conn, err := d.Dialer.Dial(ctx, addr)
+	if err != nil {
+		return nil, newError(ErrorPeerUnreachable, "", err)
+	}
+	return &monitoredConn{Conn: conn, m: d.m, dialed: addr}, nil
+}
+
+// Accept implements wirenet.Listener.Accept.
+func (l *monitoredListener) Accept() (wirenet.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &monitoredConn{Conn: conn, m: l.m}, nil
+}
+
+// Recv implements wirenet.Conn.Recv.
+func (c *monitoredConn) Recv() (*wire.Envelope, error) {
+	e, err := c.Conn.Recv()
+	if err != nil {
+		c.lose()
+		return nil, err
+	}
+	if c.dialed != nil && !e.Sender.Equal(c.dialed) {
+		c.Close()
+		return nil, errors.WithMessagef(errPeerImpersonated, "dialed %v, received envelope from %v", c.dialed, e.Sender)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.peer == nil && !c.lost {
+		c.peer = c.authenticatedPeer(e)
+		c.m.connected(c.peer)
+	}
+	return e, nil
+}
+
+// authenticatedPeer returns the Perun ID of the peer. That is the dialed
+// Perun ID or the one that the transport authenticated. Only if neither is
+// known, like for accepted plain TCP connections, the sender of the first
+// envelope `e` is trusted.
+func (c *monitoredConn) authenticatedPeer(e *wire.Envelope) wire.Address {
+	if c.dialed != nil {
+		return c.dialed
+	}
+	if conn, ok := c.Conn.(authenticatedConn); ok {
+		if id, err := conn.PeerID(); err == nil {
+			return (*ethwallet.Address)(id)
+		}
+	}
+	return e.Sender
+}
+
+// Close implements wirenet.Conn.Close.
+func (c *monitoredConn) Close() error {
+	c.lose()
+	return c.Conn.Close()
+}
+
+// lose reports the connection as lost, at most once.
+func (c *monitoredConn) lose() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.lost {
+		return
+	}
+	c.lost = true
+	if c.peer != nil {
+		c.m.disconnected(c.peer)
+	}
+}
+
+// toAddress converts a wire.Address into an Address.
+func toAddress(addr wire.Address) *Address {
+	return &Address{*addr.(*ethwallet.Address)}
+}
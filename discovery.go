@@ -0,0 +1,174 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/wallet"
+
+	"github.com/perun-network/perun-eth-mobile/mdns"
+)
+
+// mdnsService is the DNS-SD service type under which clients are advertised.
+const mdnsService = "_perun._tcp.local."
+
+// TXT record keys of advertised clients.
+const (
+	txtPerunID   = "id"
+	txtAlias     = "alias"
+	txtTransport = "transport"
+)
+
+// PeerDiscoveredCallback is notified about peers that were discovered on the
+// local network.
+type PeerDiscoveredCallback interface {
+	OnPeerDiscovered(perunID *Address, alias string, host string, port int)
+}
+
+// StartAdvertising advertises the Perun ID, Alias and Port of the Client on the
+// local network via mDNS, so that peers on the same network can find it with
+// StartDiscovery. If the Client listens on an unspecified IP, the addresses
+// of all network interfaces are advertised.
+// On Android, a WifiManager.MulticastLock must be held while advertising.
+func (c *Client) StartAdvertising() (err error) {
+	defer func() { err = toError(err) }()
+	c.discoveryMutex.Lock()
+	defer c.discoveryMutex.Unlock()
+	if c.responder != nil {
+		return newError(ErrorInvalidState, "", errors.New("already advertising"))
+	}
+
+	ips, err := advertisedIPs(c.cfg.IP)
+	if err != nil {
+		return newError(ErrorInvalidArgument, "", errors.WithMessage(err, "determining IP addresses"))
+	}
+	id := c.cfg.Address.ToHex()
+	transport := c.cfg.Transport
+	if transport == "" {
+		transport = TransportTCP
+	}
+	svc := mdns.Service{
+		Instance: id,
+		Host:     id + ".local.",
+		Port:     c.cfg.Port,
+		IPs:      ips,
+		Text: map[string]string{
+			txtPerunID:   id,
+			txtAlias:     c.cfg.Alias,
+			txtTransport: transport,
+		},
+	}
+	c.responder, err = mdns.Advertise(mdnsService, svc)
+	return errors.WithMessage(err, "advertising via mDNS")
+}
+
+// StartDiscovery searches the local network for advertised peers that use the
+// same Transport. Every found peer is registered like with AddPeer and then
+// passed to the callback `cb`. Peers that were added with AddPeer keep their
+// address. A peer is reported again if its network address changed.
+// If discovery fails later on, it is stopped and can be started again.
+// On Android, a WifiManager.MulticastLock must be held while discovering.
+func (c *Client) StartDiscovery(cb PeerDiscoveredCallback) (err error) {
+	defer func() { err = toError(err) }()
+	c.discoveryMutex.Lock()
+	defer c.discoveryMutex.Unlock()
+	if c.stopBrowsing != nil {
+		return newError(ErrorInvalidState, "", errors.New("already discovering"))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done, err := mdns.Browse(ctx, mdnsService, func(svc mdns.Service) { c.onDiscovered(svc, cb) })
+	if err != nil {
+		cancel()
+		return errors.WithMessage(err, "discovering via mDNS")
+	}
+	c.stopBrowsing = cancel
+	go func() {
+		err := <-done
+		if err == nil {
+			return
+		}
+		log.WithError(err).Error("Peer discovery stopped")
+		c.discoveryMutex.Lock()
+		defer c.discoveryMutex.Unlock()
+		// If ctx is not canceled, this discovery was not stopped yet.
+		if ctx.Err() == nil {
+			c.stopBrowsing()
+			c.stopBrowsing = nil
+		}
+	}()
+	return nil
+}
+
+// StopDiscovery stops advertising the Client and discovering peers.
+// This function may be safely called at any time.
+func (c *Client) StopDiscovery() {
+	c.discoveryMutex.Lock()
+	defer c.discoveryMutex.Unlock()
+	if c.responder != nil {
+		if err := c.responder.Close(); err != nil {
+			log.WithError(err).Warn("Stopping mDNS advertisement")
+		}
+		c.responder = nil
+	}
+	if c.stopBrowsing != nil {
+		c.stopBrowsing()
+		c.stopBrowsing = nil
+	}
+}
+
+// onDiscovered adds the peer that advertised `svc` and reports it to `cb`.
+func (c *Client) onDiscovered(svc mdns.Service, cb PeerDiscoveredCallback) {
+	perunID, err := NewAddressFromHex(svc.Text[txtPerunID])
+	if err != nil {
+		log.WithError(err).Debug("Ignored peer with invalid Perun ID")
+		return
+	}
+	transport := c.cfg.Transport
+	if transport == "" {
+		transport = TransportTCP
+	}
+	if perunID.addr == c.cfg.Address.addr || svc.Text[txtTransport] != transport {
+		return
+	}
+	host := svc.IPs[0].String()
+	c.discoveryMutex.Lock()
+	if _, added := c.addedPeers[wallet.Key(&perunID.addr)]; !added {
+		c.dialer.Register(&perunID.addr, fmt.Sprintf("%s:%d", host, svc.Port))
+	}
+	c.discoveryMutex.Unlock()
+	cb.OnPeerDiscovered(perunID, svc.Text[txtAlias], host, int(svc.Port))
+}
+
+// advertisedIPs returns `ip` or, if it is unspecified, the IPv4 addresses of
+// all network interfaces except loopback.
+func advertisedIPs(ip string) ([]net.IP, error) {
+	if parsed := net.ParseIP(ip); parsed != nil && !parsed.IsUnspecified() {
+		return []net.IP{parsed}, nil
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil && !strings.HasPrefix(ipNet.IP.String(), "169.254.") {
+			ips = append(ips, ipNet.IP.To4())
+		}
+	}
+	if len(ips) == 0 {
+		return nil, errors.New("no network interface with IPv4 address")
+	}
+	return ips, nil
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+)
+
+// Phases of a registered channel as returned by ChannelStatus.GetPhase. They
+// match the phases of the Adjudicator contract.
+const (
+	// PhaseDispute means that a state was registered and can be refuted
+	// until the timeout.
+	PhaseDispute = iota
+	// PhaseForceExec means that the channel is progressed on-chain.
+	PhaseForceExec
+	// PhaseConcluded means that the channel is concluded and can be
+	// withdrawn.
+	PhaseConcluded
+)
+
+// adjudicatorDisputesABI is the ABI of the `disputes` getter of the
+// Adjudicator contract.
+const adjudicatorDisputesABI = `[{"inputs":[{"internalType":"bytes32","name":"","type":"bytes32"}],"name":"disputes","outputs":[{"internalType":"uint64","name":"timeout","type":"uint64"},{"internalType":"uint64","name":"challengeDuration","type":"uint64"},{"internalType":"uint64","name":"version","type":"uint64"},{"internalType":"bool","name":"hasApp","type":"bool"},{"internalType":"uint8","name":"phase","type":"uint8"},{"internalType":"bytes32","name":"stateHash","type":"bytes32"}],"stateMutability":"view","type":"function"}]`
+
+// adjudicatorDisputes is the parsed adjudicatorDisputesABI.
+var adjudicatorDisputes = mustParseABI(adjudicatorDisputesABI)
+
+type (
+	// ChannelStatus is the on-chain status of a channel at the Adjudicator.
+	ChannelStatus struct {
+		registered bool
+		version    uint64
+		phase      int
+		timeout    uint64 // unix timestamp in seconds
+		now        uint64 // timestamp of the latest block
+	}
+
+	// dispute is the Dispute struct of the Adjudicator contract.
+	dispute struct {
+		Timeout           uint64
+		ChallengeDuration uint64
+		Version           uint64
+		HasApp            bool
+		Phase             uint8
+		StateHash         [32]byte
+	}
+)
+
+// Register registers the current state of the channel on-chain. This starts
+// a dispute that the peer can refute with a newer state until the challenge
+// duration elapsed. Use OnChainStatus to follow the dispute and Withdraw to
+// withdraw the funds afterwards.
+// ref https://pkg.go.dev/perun.network/go-perun/client?tab=doc#Channel.Register
+func (c *PaymentChannel) Register(ctx *Context) error {
+	return toError(c.ch.Register(ctx.ctx))
+}
+
+// OnChainStatus returns the status of the channel at the Adjudicator.
+func (c *PaymentChannel) OnChainStatus(ctx *Context) (*ChannelStatus, error) {
+	status, err := c.c.onChainStatus(ctx.ctx, c.ch.ID())
+	return status, toError(err)
+}
+
+// Withdraw withdraws the funds of the channel. It fails if the channel is
+// not final and either not registered or still in its challenge duration.
+// Since the dispute is stored on-chain, Withdraw can be used to resume a
+// settlement that was interrupted, e.g. because the app was killed.
+// See Settle for `secondary`.
+func (c *PaymentChannel) Withdraw(ctx *Context, secondary bool) error {
+	status, err := c.OnChainStatus(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !status.registered && !c.ch.State().IsFinal:
+		return newError(ErrorInvalidState, "", errors.New("channel is neither final nor registered"))
+	case status.registered && !status.IsConcludable():
+		return newError(ErrorInvalidState, "", errors.Errorf("challenge duration not elapsed, %d seconds remaining", status.GetRemainingSeconds()))
+	}
+	return c.Settle(ctx, secondary)
+}
+
+// onChainStatus queries the Adjudicator for the dispute of channel `id`.
+func (c *Client) onChainStatus(ctx context.Context, id channel.ID) (*ChannelStatus, error) {
+	adj := bind.NewBoundContract(common.Address(c.cfg.Adjudicator.addr), adjudicatorDisputes, c.ethClient, nil, nil)
+	var d dispute
+	out := []interface{}{&d}
+	if err := adj.Call(&bind.CallOpts{Context: ctx}, &out, "disputes", id); err != nil {
+		return nil, errors.WithMessage(err, "querying dispute")
+	}
+	head, err := c.ethClient.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, errors.WithMessage(err, "querying latest block")
+	}
+	return &ChannelStatus{
+		registered: d.Timeout != 0 || d.StateHash != [32]byte{},
+		version:    d.Version,
+		phase:      int(d.Phase),
+		timeout:    d.Timeout,
+		now:        head.Time,
+	}, nil
+}
+
+// IsRegistered returns whether a state of the channel was registered.
+func (s *ChannelStatus) IsRegistered() bool {
+	return s.registered
+}
+
+// GetVersion returns the registered version.
+func (s *ChannelStatus) GetVersion() int64 {
+	return int64(s.version)
+}
+
+// GetPhase returns the phase of a registered channel, one of PhaseDispute,
+// PhaseForceExec or PhaseConcluded.
+func (s *ChannelStatus) GetPhase() int {
+	return s.phase
+}
+
+// GetTimeout returns the end of the current phase as unix timestamp in
+// seconds.
+func (s *ChannelStatus) GetTimeout() int64 {
+	return int64(s.timeout)
+}
+
+// GetRemainingSeconds returns the seconds until the current phase ends,
+// measured by the timestamp of the latest block.
+func (s *ChannelStatus) GetRemainingSeconds() int64 {
+	if s.timeout <= s.now {
+		return 0
+	}
+	return int64(s.timeout - s.now)
+}
+
+// IsConcludable returns whether the registered channel can be concluded and
+// withdrawn.
+func (s *ChannelStatus) IsConcludable() bool {
+	return s.registered && (s.phase == PhaseConcluded || s.GetRemainingSeconds() == 0)
+}
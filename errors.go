@@ -0,0 +1,126 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	ethkeystore "github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/client"
+)
+
+// Error codes as returned by ClientError.GetCode.
+const (
+	// ErrorUnknown is an error that does not fit any other category.
+	ErrorUnknown = iota
+	// ErrorPeerUnreachable means that the peer could not be dialed or did
+	// not respond in time.
+	ErrorPeerUnreachable
+	// ErrorPeerImpersonated means that the dialed peer did not prove the
+	// expected Perun ID.
+	ErrorPeerImpersonated
+	// ErrorRejected means that the peer rejected a proposal or update. The
+	// reason of the peer is available through ClientError.GetDetails.
+	ErrorRejected
+	// ErrorInsufficientFunds means that an on-chain account cannot pay for a
+	// transaction.
+	ErrorInsufficientFunds
+	// ErrorFundingTimeout means that a new channel was not funded in time.
+	ErrorFundingTimeout
+	// ErrorInvalidState means that a channel state or transition is invalid.
+	ErrorInvalidState
+	// ErrorInvalidArgument means that a passed argument violates the Config
+	// or the requirements of the function.
+	ErrorInvalidArgument
+	// ErrorPersistence means that the database could not be accessed.
+	ErrorPersistence
+	// ErrorWrongPassword means that the keystore could not be unlocked.
+	ErrorWrongPassword
+	// ErrorInvalidContracts means that the configured contracts are not
+	// deployed or do not belong together.
+	ErrorInvalidContracts
+)
+
+// errPeerImpersonated is returned when a peer sends envelopes under another
+// Perun ID than the one that it was dialed with or authenticated as.
+var errPeerImpersonated = errors.New("peer impersonated")
+
+// ClientError is the error returned by the functions of this package. In
+// Java, it can be caught as prnm.ClientError and be distinguished by its
+// code instead of its message.
+type ClientError struct {
+	code    int
+	details string
+	err     error
+}
+
+// newError returns a ClientError with `code` that wraps `err`.
+func newError(code int, details string, err error) *ClientError {
+	return &ClientError{code: code, details: details, err: err}
+}
+
+// GetCode returns the category of the error, one of the Error* constants.
+func (e *ClientError) GetCode() int {
+	return e.code
+}
+
+// GetDetails returns additional details, like the reason of a rejection, or
+// an empty string.
+func (e *ClientError) GetDetails() string {
+	return e.details
+}
+
+func (e *ClientError) Error() string {
+	return e.err.Error()
+}
+
+// Cause returns the wrapped error.
+func (e *ClientError) Cause() error { return e.err }
+
+// Unwrap returns the wrapped error.
+func (e *ClientError) Unwrap() error { return e.err }
+
+// toError classifies `err` and returns it as ClientError. Returns nil if
+// `err` is nil and `err` itself if it already is a ClientError.
+func toError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if clientErr, ok := err.(*ClientError); ok {
+		return clientErr
+	}
+	var (
+		clientErr   *ClientError
+		rejected    client.PeerRejectedError
+		timedOut    client.RequestTimedOutError
+		proposalErr *ProposalTimeoutError
+		fundingErr  *FundingTimeoutError
+		durationErr *ChallengeDurationError
+	)
+	switch {
+	case errors.As(err, &clientErr):
+		return newError(clientErr.code, clientErr.details, err)
+	case errors.As(err, &rejected):
+		return newError(ErrorRejected, rejected.Reason, err)
+	case errors.As(err, &fundingErr), channel.IsFundingTimeoutError(err):
+		return newError(ErrorFundingTimeout, "", err)
+	case errors.As(err, &proposalErr), errors.As(err, &timedOut), errors.Is(err, errPeerUnreachable):
+		return newError(ErrorPeerUnreachable, "", err)
+	case errors.Is(err, errPeerImpersonated):
+		return newError(ErrorPeerImpersonated, "", err)
+	case errors.As(err, &durationErr):
+		return newError(ErrorInvalidArgument, "", err)
+	case errors.Is(err, ethkeystore.ErrDecrypt):
+		return newError(ErrorWrongPassword, "", err)
+	case errors.Is(err, core.ErrInsufficientFunds), errors.Is(err, core.ErrInsufficientFundsForTransfer):
+		return newError(ErrorInsufficientFunds, "", err)
+	case channel.IsStateTransitionError(err), channel.IsActionError(err):
+		return newError(ErrorInvalidState, "", err)
+	}
+	return newError(ErrorUnknown, "", err)
+}
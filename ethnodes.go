@@ -0,0 +1,589 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/log"
+)
+
+const (
+	// ethHealthInterval is the interval in which all ETH nodes are checked.
+	ethHealthInterval = 15 * time.Second
+	// ethHealthTimeout is the timeout of a single health check.
+	ethHealthTimeout = 5 * time.Second
+	// ethResubscribeBackoff is the maximal time between two attempts to renew
+	// a failed subscription.
+	ethResubscribeBackoff = 10 * time.Second
+)
+
+type (
+	// ethNodes is an ethchannel.ContractInterface that forwards every call to
+	// one of several ETH nodes. If that node fails, the call is repeated at
+	// the next healthy node. Subscriptions are renewed at another node if
+	// their node fails. Subscriptions at HTTP nodes are emulated by polling.
+	ethNodes struct {
+		chainID      *big.Int
+		pollInterval time.Duration
+		closed       <-chan struct{}
+
+		mutex   sync.Mutex
+		nodes   []*ethNode // in order of preference
+		current int        // index of the node that is used
+	}
+
+	// ethNode is a single ETH node of ethNodes.
+	ethNode struct {
+		url     string
+		client  *ethclient.Client // nil if it could not be dialed yet
+		healthy bool
+	}
+
+	// joinedSub is a subscription that unsubscribes from all of its parts.
+	joinedSub []event.Subscription
+
+	// txError is an error of a node that rejected a transaction. It is
+	// recognized as the go-ethereum error `cause` by errors.Is.
+	txError struct {
+		cause, err error
+	}
+)
+
+// txCauses are the go-ethereum errors that are restored by asTxError.
+var txCauses = []error{
+	core.ErrInsufficientFunds,
+	core.ErrInsufficientFundsForTransfer,
+	core.ErrNonceTooLow,
+	core.ErrAlreadyKnown,
+}
+
+// splitURLs splits a comma separated list of URLs.
+func splitURLs(urls string) []string {
+	var split []string
+	for _, url := range strings.Split(urls, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			split = append(split, url)
+		}
+	}
+	return split
+}
+
+// dialETHNodes connects to the ETH nodes at `urls`. At least one node must
+// be reachable and all reachable nodes must report the same chain ID.
+// Unreachable nodes are dialed again by the health checks, which run until
+// `closed` is closed. HTTP nodes are polled every `pollInterval`.
+func dialETHNodes(ctx context.Context, urls []string, pollInterval time.Duration, closed <-chan struct{}) (*ethNodes, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("no ethereum node configured")
+	}
+	n := &ethNodes{pollInterval: pollInterval, closed: closed, current: -1}
+	for i, url := range urls {
+		node := &ethNode{url: url}
+		n.nodes = append(n.nodes, node)
+		if err := n.check(ctx, node); err != nil {
+			if errors.Is(err, errChainIDMismatch) {
+				n.close()
+				return nil, errors.WithMessagef(err, "ethereum node %s", url)
+			}
+			log.WithError(err).WithField("url", url).Warn("Ethereum node unreachable")
+			continue
+		}
+		if n.current < 0 {
+			n.current = i
+		}
+	}
+	if n.current < 0 {
+		n.close()
+		return nil, errors.New("no ethereum node reachable")
+	}
+	go n.checkHealth()
+	return n, nil
+}
+
+// errChainIDMismatch is returned by ethNodes.check if a node reports another
+// chain ID than the other nodes.
+var errChainIDMismatch = errors.New("chain ID mismatch")
+
+// check dials `node` if needed, checks its chain ID and updates its health.
+// The first node that is checked determines the chain ID.
+func (n *ethNodes) check(ctx context.Context, node *ethNode) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, ethHealthTimeout)
+	defer cancel()
+	defer func() {
+		n.mutex.Lock()
+		node.healthy = err == nil
+		n.mutex.Unlock()
+	}()
+
+	n.mutex.Lock()
+	cl := node.client
+	n.mutex.Unlock()
+	if cl == nil {
+		if cl, err = ethclient.DialContext(ctx, node.url); err != nil {
+			return errors.WithMessage(err, "dialing")
+		}
+		n.mutex.Lock()
+		node.client = cl
+		n.mutex.Unlock()
+	}
+	id, err := cl.ChainID(ctx)
+	if err != nil {
+		return errors.WithMessage(err, "querying chain ID")
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.chainID == nil {
+		n.chainID = id
+	} else if n.chainID.Cmp(id) != 0 {
+		return errors.Wrapf(errChainIDMismatch, "expected %v, got %v", n.chainID, id)
+	}
+	return nil
+}
+
+// checkHealth periodically checks all nodes and switches back to the most
+// preferred healthy node. It closes all nodes once `closed` is closed.
+func (n *ethNodes) checkHealth() {
+	ticker := time.NewTicker(ethHealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.closed:
+			n.close()
+			return
+		case <-ticker.C:
+		}
+
+		for _, node := range n.nodes {
+			if err := n.check(context.Background(), node); err != nil {
+				log.WithError(err).WithField("url", node.url).Debug("Ethereum node unhealthy")
+			}
+		}
+		n.mutex.Lock()
+		if best := n.preferred(); best >= 0 && best != n.current {
+			log.WithField("url", n.nodes[best].url).Info("Switching to ethereum node")
+			n.current = best
+		}
+		n.mutex.Unlock()
+	}
+}
+
+// preferred returns the index of the first healthy node or -1 if there is
+// none. The mutex must be held.
+func (n *ethNodes) preferred() int {
+	for i, node := range n.nodes {
+		if node.healthy {
+			return i
+		}
+	}
+	return -1
+}
+
+// close closes the connections to all nodes.
+func (n *ethNodes) close() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for _, node := range n.nodes {
+		if node.client != nil {
+			node.client.Close()
+		}
+	}
+}
+
+// pick returns the node that should be used and its index. If no node is
+// healthy, the current node is returned anyway.
+func (n *ethNodes) pick() (int, *ethclient.Client) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if !n.nodes[n.current].healthy {
+		if best := n.preferred(); best >= 0 {
+			n.current = best
+		}
+	}
+	return n.current, n.nodes[n.current].client
+}
+
+// fail marks the node at `index` as unhealthy and fails over to the next
+// healthy node.
+func (n *ethNodes) fail(index int, err error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	node := n.nodes[index]
+	if node.healthy {
+		log.WithError(err).WithField("url", node.url).Warn("Ethereum node failed")
+		node.healthy = false
+	}
+	if best := n.preferred(); best >= 0 && index == n.current {
+		log.WithField("url", n.nodes[best].url).Warn("Failing over to ethereum node")
+		n.current = best
+	}
+}
+
+// do calls `f` with the current node. If the node fails, `f` is called again
+// with the next healthy node until every node was tried once.
+func (n *ethNodes) do(ctx context.Context, f func(*ethclient.Client) error) error {
+	var err error
+	for range n.nodes {
+		index, cl := n.pick()
+		if cl == nil {
+			err = errors.New("ethereum node not connected")
+		} else if err = f(cl); !isNodeFailure(ctx, err) {
+			return err
+		}
+		n.fail(index, err)
+	}
+	return errors.WithMessage(err, "all ethereum nodes failed")
+}
+
+// isNodeFailure returns whether `err` was caused by the node rather than by
+// the call, e.g. a connection error.
+func isNodeFailure(ctx context.Context, err error) bool {
+	var rpcErr rpc.Error
+	switch {
+	case err == nil, ctx.Err() != nil, errors.Is(err, ethereum.NotFound), errors.As(err, &rpcErr):
+		return false
+	}
+	return true
+}
+
+// resubscribe returns a subscription that forwards the errors of `sub` and
+// renews it with `renew` whenever it fails.
+func (n *ethNodes) resubscribe(sub ethereum.Subscription, renew func(context.Context, *ethclient.Client) (ethereum.Subscription, error)) event.Subscription {
+	return event.ResubscribeErr(ethResubscribeBackoff, func(ctx context.Context, err error) (event.Subscription, error) {
+		if sub != nil { // The initial subscription.
+			initial := sub
+			sub = nil
+			return initial, nil
+		}
+		log.WithError(err).Warn("Ethereum subscription failed, renewing")
+		var renewed ethereum.Subscription
+		err = n.do(ctx, func(cl *ethclient.Client) (err error) {
+			renewed, err = renew(ctx, cl)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return renewed, nil
+	})
+}
+
+// SubscribeFilterLogs subscribes to the logs of `q`. If the subscription is
+// renewed at another node, the logs that were emitted in the meantime are
+// fetched from that node and already forwarded logs are dropped.
+func (n *ethNodes) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, logs chan<- types.Log) (ethereum.Subscription, error) {
+	in := make(chan types.Log)
+	var mutex sync.Mutex
+	var last types.Log // last forwarded log, zero if none
+	fwd := event.NewSubscription(func(quit <-chan struct{}) error {
+		for {
+			var l types.Log
+			select {
+			case l = <-in:
+			case <-quit:
+				return nil
+			}
+			mutex.Lock()
+			dup := !l.Removed && last.BlockHash != (common.Hash{}) &&
+				(l.BlockNumber < last.BlockNumber || l.BlockNumber == last.BlockNumber && l.Index <= last.Index)
+			if l.Removed {
+				last = types.Log{} // The chain was reorganized.
+			} else if !dup {
+				last = l
+			}
+			mutex.Unlock()
+			if dup {
+				continue
+			}
+			select {
+			case logs <- l:
+			case <-quit:
+				return nil
+			}
+		}
+	})
+
+	var sub ethereum.Subscription
+	if err := n.do(ctx, func(cl *ethclient.Client) (err error) {
+		sub, err = n.subscribeLogs(ctx, cl, q, in)
+		return err
+	}); err != nil {
+		fwd.Unsubscribe()
+		return nil, err
+	}
+	resub := n.resubscribe(sub, func(ctx context.Context, cl *ethclient.Client) (ethereum.Subscription, error) {
+		sub, err := n.subscribeLogs(ctx, cl, q, in)
+		if err != nil {
+			return nil, err
+		}
+		mutex.Lock()
+		from := last
+		mutex.Unlock()
+		if from.BlockHash == (common.Hash{}) || q.BlockHash != nil {
+			return sub, nil
+		}
+		missed := q
+		missed.FromBlock, missed.ToBlock = new(big.Int).SetUint64(from.BlockNumber), nil
+		past, err := cl.FilterLogs(ctx, missed)
+		if err != nil {
+			sub.Unsubscribe()
+			return nil, err
+		}
+		for _, l := range past {
+			select {
+			case in <- l:
+			case <-ctx.Done():
+				sub.Unsubscribe()
+				return nil, ctx.Err()
+			}
+		}
+		return sub, nil
+	})
+	return joinedSub{resub, fwd}, nil
+}
+
+// SubscribeNewHead subscribes to new block headers. The subscription is
+// renewed at another node if its node fails.
+func (n *ethNodes) SubscribeNewHead(ctx context.Context, heads chan<- *types.Header) (ethereum.Subscription, error) {
+	var sub ethereum.Subscription
+	if err := n.do(ctx, func(cl *ethclient.Client) (err error) {
+		sub, err = n.subscribeHeads(ctx, cl, heads)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return n.resubscribe(sub, func(ctx context.Context, cl *ethclient.Client) (ethereum.Subscription, error) {
+		return n.subscribeHeads(ctx, cl, heads)
+	}), nil
+}
+
+// Unsubscribe unsubscribes from all parts of the subscription.
+func (s joinedSub) Unsubscribe() {
+	for _, sub := range s {
+		sub.Unsubscribe()
+	}
+}
+
+// Err returns the error channel of the first part of the subscription.
+func (s joinedSub) Err() <-chan error {
+	return s[0].Err()
+}
+
+// ChainID returns the chain ID that all nodes agree on.
+func (n *ethNodes) ChainID(context.Context) (*big.Int, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return new(big.Int).Set(n.chainID), nil
+}
+
+// BalanceAt returns the balance of `account` at block `number`.
+func (n *ethNodes) BalanceAt(ctx context.Context, account common.Address, number *big.Int) (bal *big.Int, err error) {
+	err = n.do(ctx, func(cl *ethclient.Client) (err error) {
+		bal, err = cl.BalanceAt(ctx, account, number)
+		return err
+	})
+	return
+}
+
+// CodeAt returns the code of `contract` at block `number`.
+func (n *ethNodes) CodeAt(ctx context.Context, contract common.Address, number *big.Int) (code []byte, err error) {
+	err = n.do(ctx, func(cl *ethclient.Client) (err error) {
+		code, err = cl.CodeAt(ctx, contract, number)
+		return err
+	})
+	return
+}
+
+// CallContract executes `call` at block `number`.
+func (n *ethNodes) CallContract(ctx context.Context, call ethereum.CallMsg, number *big.Int) (res []byte, err error) {
+	err = n.do(ctx, func(cl *ethclient.Client) (err error) {
+		res, err = cl.CallContract(ctx, call, number)
+		return err
+	})
+	return
+}
+
+// HeaderByHash returns the block header with `hash`.
+func (n *ethNodes) HeaderByHash(ctx context.Context, hash common.Hash) (h *types.Header, err error) {
+	err = n.do(ctx, func(cl *ethclient.Client) (err error) {
+		h, err = cl.HeaderByHash(ctx, hash)
+		return err
+	})
+	return
+}
+
+// HeaderByNumber returns the block header at `number` or the latest one if
+// `number` is nil.
+func (n *ethNodes) HeaderByNumber(ctx context.Context, number *big.Int) (h *types.Header, err error) {
+	err = n.do(ctx, func(cl *ethclient.Client) (err error) {
+		h, err = cl.HeaderByNumber(ctx, number)
+		return err
+	})
+	return
+}
+
+// BlockByHash returns the block with `hash`.
+func (n *ethNodes) BlockByHash(ctx context.Context, hash common.Hash) (b *types.Block, err error) {
+	err = n.do(ctx, func(cl *ethclient.Client) (err error) {
+		b, err = cl.BlockByHash(ctx, hash)
+		return err
+	})
+	return
+}
+
+// BlockByNumber returns the block at `number` or the latest one if `number`
+// is nil.
+func (n *ethNodes) BlockByNumber(ctx context.Context, number *big.Int) (b *types.Block, err error) {
+	err = n.do(ctx, func(cl *ethclient.Client) (err error) {
+		b, err = cl.BlockByNumber(ctx, number)
+		return err
+	})
+	return
+}
+
+// TransactionCount returns the number of transactions in the block `hash`.
+func (n *ethNodes) TransactionCount(ctx context.Context, hash common.Hash) (count uint, err error) {
+	err = n.do(ctx, func(cl *ethclient.Client) (err error) {
+		count, err = cl.TransactionCount(ctx, hash)
+		return err
+	})
+	return
+}
+
+// TransactionInBlock returns the transaction at `index` in the block `hash`.
+func (n *ethNodes) TransactionInBlock(ctx context.Context, hash common.Hash, index uint) (tx *types.Transaction, err error) {
+	err = n.do(ctx, func(cl *ethclient.Client) (err error) {
+		tx, err = cl.TransactionInBlock(ctx, hash, index)
+		return err
+	})
+	return
+}
+
+// TransactionByHash returns the transaction with `hash`.
+func (n *ethNodes) TransactionByHash(ctx context.Context, hash common.Hash) (tx *types.Transaction, pending bool, err error) {
+	err = n.do(ctx, func(cl *ethclient.Client) (err error) {
+		tx, pending, err = cl.TransactionByHash(ctx, hash)
+		return err
+	})
+	return
+}
+
+// TransactionReceipt returns the receipt of the transaction with `hash`.
+func (n *ethNodes) TransactionReceipt(ctx context.Context, hash common.Hash) (r *types.Receipt, err error) {
+	err = n.do(ctx, func(cl *ethclient.Client) (err error) {
+		r, err = cl.TransactionReceipt(ctx, hash)
+		return err
+	})
+	return
+}
+
+// PendingCodeAt returns the code of `account` in the pending state.
+func (n *ethNodes) PendingCodeAt(ctx context.Context, account common.Address) (code []byte, err error) {
+	err = n.do(ctx, func(cl *ethclient.Client) (err error) {
+		code, err = cl.PendingCodeAt(ctx, account)
+		return err
+	})
+	return
+}
+
+// PendingNonceAt returns the nonce of `account` in the pending state.
+func (n *ethNodes) PendingNonceAt(ctx context.Context, account common.Address) (nonce uint64, err error) {
+	err = n.do(ctx, func(cl *ethclient.Client) (err error) {
+		nonce, err = cl.PendingNonceAt(ctx, account)
+		return err
+	})
+	return
+}
+
+// SuggestGasPrice returns the gas price that the node suggests.
+func (n *ethNodes) SuggestGasPrice(ctx context.Context) (price *big.Int, err error) {
+	err = n.do(ctx, func(cl *ethclient.Client) (err error) {
+		price, err = cl.SuggestGasPrice(ctx)
+		return err
+	})
+	return
+}
+
+// SuggestGasTipCap returns the gas tip cap that the node suggests.
+func (n *ethNodes) SuggestGasTipCap(ctx context.Context) (tip *big.Int, err error) {
+	err = n.do(ctx, func(cl *ethclient.Client) (err error) {
+		tip, err = cl.SuggestGasTipCap(ctx)
+		return err
+	})
+	return
+}
+
+// EstimateGas estimates the gas that `call` needs.
+func (n *ethNodes) EstimateGas(ctx context.Context, call ethereum.CallMsg) (gas uint64, err error) {
+	err = n.do(ctx, func(cl *ethclient.Client) (err error) {
+		gas, err = cl.EstimateGas(ctx, call)
+		return err
+	})
+	return gas, asTxError(err)
+}
+
+// SendTransaction sends `tx`. Sending it again at another node is safe
+// since a signed transaction can only be included once.
+func (n *ethNodes) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	return asTxError(n.do(ctx, func(cl *ethclient.Client) error {
+		return cl.SendTransaction(ctx, tx)
+	}))
+}
+
+// asTxError returns a txError if `err` was reported by a node for one of
+// the txCauses. JSON-RPC only transmits the messages of errors, so they are
+// recognized by the message that go-ethereum uses.
+func asTxError(err error) error {
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return err
+	}
+	for _, cause := range txCauses {
+		if strings.HasPrefix(rpcErr.Error(), cause.Error()) {
+			return &txError{cause: cause, err: err}
+		}
+	}
+	return err
+}
+
+func (e *txError) Error() string { return e.err.Error() }
+
+// Is returns whether `target` is the go-ethereum error of e.
+func (e *txError) Is(target error) bool { return target == e.cause }
+
+// Unwrap returns the error of the node.
+func (e *txError) Unwrap() error { return e.err }
+
+// FilterLogs returns the logs that match `q`.
+func (n *ethNodes) FilterLogs(ctx context.Context, q ethereum.FilterQuery) (logs []types.Log, err error) {
+	err = n.do(ctx, func(cl *ethclient.Client) (err error) {
+		logs, err = cl.FilterLogs(ctx, q)
+		return err
+	})
+	return
+}
+
+// NonceAt returns the nonce of `account` at block `number`.
+func (n *ethNodes) NonceAt(ctx context.Context, account common.Address, number *big.Int) (nonce uint64, err error) {
+	err = n.do(ctx, func(cl *ethclient.Client) (err error) {
+		nonce, err = cl.NonceAt(ctx, account, number)
+		return err
+	})
+	return
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+const (
+	// defaultPollInterval is the interval in which HTTP nodes are polled if
+	// Config.PollInterval is not set.
+	defaultPollInterval = 2 * time.Second
+	// maxLogRange is the maximal number of blocks whose logs are queried at
+	// once. Hosted nodes often reject larger ranges.
+	maxLogRange = 1000
+)
+
+// isHTTP returns whether `url` is an HTTP endpoint, which does not support
+// subscriptions.
+func isHTTP(url string) bool {
+	url = strings.ToLower(url)
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+// polled returns whether subscriptions at `cl` must be emulated by polling.
+func (n *ethNodes) polled(cl *ethclient.Client) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for _, node := range n.nodes {
+		if node.client == cl {
+			return isHTTP(node.url)
+		}
+	}
+	return false
+}
+
+// subscribeLogs subscribes to the logs of `q` at `cl`.
+func (n *ethNodes) subscribeLogs(ctx context.Context, cl *ethclient.Client, q ethereum.FilterQuery, logs chan<- types.Log) (ethereum.Subscription, error) {
+	if n.polled(cl) {
+		return n.pollLogs(ctx, cl, q, logs)
+	}
+	return cl.SubscribeFilterLogs(ctx, q, logs)
+}
+
+// subscribeHeads subscribes to new block headers at `cl`.
+func (n *ethNodes) subscribeHeads(ctx context.Context, cl *ethclient.Client, heads chan<- *types.Header) (ethereum.Subscription, error) {
+	if n.polled(cl) {
+		return n.pollHeads(ctx, cl, heads)
+	}
+	return cl.SubscribeNewHead(ctx, heads)
+}
+
+// pollLogs emulates a subscription to the logs of `q` by querying the logs of
+// every new block with eth_getLogs. Like a real subscription, it starts at
+// the next block. Every poll queries at most maxLogRange blocks, so after a
+// long pause, the subscription catches up over several polls. Logs that are
+// removed by a chain reorganization are not reported. The subscription fails
+// with the first failed query.
+func (n *ethNodes) pollLogs(ctx context.Context, cl *ethclient.Client, q ethereum.FilterQuery, logs chan<- types.Log) (ethereum.Subscription, error) {
+	head, err := cl.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	next := new(big.Int).Add(head.Number, big.NewInt(1))
+	return n.poll(func(ctx context.Context, quit <-chan struct{}) error {
+		head, err := cl.HeaderByNumber(ctx, nil)
+		if err != nil || head.Number.Cmp(next) < 0 {
+			return err
+		}
+		to := new(big.Int).Add(next, big.NewInt(maxLogRange-1))
+		if to.Cmp(head.Number) > 0 {
+			to = head.Number
+		}
+		pq := q
+		pq.FromBlock, pq.ToBlock = next, to
+		found, err := cl.FilterLogs(ctx, pq)
+		if err != nil {
+			return err
+		}
+		for _, l := range found {
+			select {
+			case logs <- l:
+			case <-quit:
+				return nil
+			}
+		}
+		next = new(big.Int).Add(to, big.NewInt(1))
+		return nil
+	}), nil
+}
+
+// pollHeads emulates a subscription to new block headers by querying the
+// latest header. Only the latest header is reported; headers that were mined
+// since the last query are skipped.
+func (n *ethNodes) pollHeads(ctx context.Context, cl *ethclient.Client, heads chan<- *types.Header) (ethereum.Subscription, error) {
+	head, err := cl.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	last := head.Number
+	return n.poll(func(ctx context.Context, quit <-chan struct{}) error {
+		head, err := cl.HeaderByNumber(ctx, nil)
+		if err != nil || head.Number.Cmp(last) <= 0 {
+			return err
+		}
+		select {
+		case heads <- head:
+		case <-quit:
+			return nil
+		}
+		last = head.Number
+		return nil
+	}), nil
+}
+
+// poll returns a subscription that calls `query` every poll interval until
+// it is unsubscribed or `query` fails.
+func (n *ethNodes) poll(query func(context.Context, <-chan struct{}) error) event.Subscription {
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		ticker := time.NewTicker(n.pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return nil
+			case <-ticker.C:
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), ethHealthTimeout)
+			err := query(ctx, quit)
+			cancel()
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
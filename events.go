@@ -0,0 +1,173 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"math/big"
+	"sync"
+
+	"perun.network/go-perun/channel"
+)
+
+// Types of events as returned by Event.GetType.
+const (
+	// EventChannelOpened is emitted when a channel is created or restored.
+	EventChannelOpened = iota
+	// EventChannelFunded is emitted when a newly created channel is funded.
+	EventChannelFunded
+	// EventChannelUpdated is emitted whenever a new channel state was agreed
+	// upon. The state is available through Event.GetState.
+	EventChannelUpdated
+	// EventPaymentSent is emitted when a payment to the peer was accepted.
+	EventPaymentSent
+	// EventPaymentReceived is emitted when a payment from the peer was
+	// accepted.
+	EventPaymentReceived
+	// EventChannelRegistered is emitted when a state of the channel was
+	// registered on-chain.
+	EventChannelRegistered
+	// EventChannelProgressed is emitted when the channel was progressed
+	// on-chain.
+	EventChannelProgressed
+	// EventChannelConcluded is emitted when the channel was concluded
+	// on-chain.
+	EventChannelConcluded
+	// EventChannelWithdrawn is emitted when the funds of the channel were
+	// withdrawn.
+	EventChannelWithdrawn
+	// EventWatcherError is emitted when the watcher of a channel stopped with
+	// an error.
+	EventWatcherError
+	// EventTxReorged is emitted when a mined transaction of the Client was
+	// removed from the canonical chain or moved to another block by a chain
+	// reorganization. It has no channel ID; the transaction hash is available
+	// through Event.GetTxHash.
+	EventTxReorged
+	// EventForceCloseCompleted is emitted when a force-close that was resumed
+	// in the background withdrew the funds of the channel.
+	EventForceCloseCompleted
+)
+
+type (
+	// EventListener receives all events of a Client. See the Event* constants
+	// for the types of events.
+	EventListener interface {
+		// OnEvent is called synchronously by the routine that caused the
+		// event and should therefore return quickly.
+		OnEvent(*Event)
+	}
+
+	// Event is an event of a channel of the Client.
+	Event struct {
+		typ     int
+		id      channel.ID
+		version uint64
+		state   *channel.State // nil if not available
+		amount  *big.Int       // nil if not a payment
+		err     error          // nil if not an error
+		txHash  string         // only set for EventTxReorged
+	}
+
+	// eventEmitter passes events to the EventListener of a Client.
+	eventEmitter struct {
+		mutex    sync.Mutex
+		listener EventListener // nil if not set
+	}
+)
+
+// SetEventListener sets a listener to receive all events of the Client.
+// Only one such listener can be set at a time, and repeated calls to this
+// function will overwrite the currently existing listener. This function may
+// be safely called at any time.
+func (c *Client) SetEventListener(l EventListener) {
+	c.events.mutex.Lock()
+	defer c.events.mutex.Unlock()
+	c.events.listener = l
+}
+
+// emit passes `e` to the EventListener, if one is set.
+func (em *eventEmitter) emit(e *Event) {
+	em.mutex.Lock()
+	l := em.listener
+	em.mutex.Unlock()
+	if l != nil {
+		l.OnEvent(e)
+	}
+}
+
+// emitState emits an event of type `typ` for the state `s`.
+func (em *eventEmitter) emitState(typ int, s *channel.State) {
+	em.emit(&Event{typ: typ, id: s.ID, version: s.Version, state: s})
+}
+
+// emitPayment emits a payment event of type `typ` for the state `s`.
+func (em *eventEmitter) emitPayment(typ int, s *channel.State, amount *big.Int) {
+	em.emit(&Event{typ: typ, id: s.ID, version: s.Version, state: s, amount: amount})
+}
+
+// emitAdjudicatorEvent emits the event that corresponds to `e`, if any.
+func (em *eventEmitter) emitAdjudicatorEvent(e channel.AdjudicatorEvent) {
+	ev := &Event{id: e.ID(), version: e.Version()}
+	switch e := e.(type) {
+	case *channel.RegisteredEvent:
+		ev.typ, ev.state = EventChannelRegistered, e.State
+	case *channel.ProgressedEvent:
+		ev.typ, ev.state = EventChannelProgressed, e.State
+	case *channel.ConcludedEvent:
+		ev.typ = EventChannelConcluded
+	default:
+		return
+	}
+	em.emit(ev)
+}
+
+// GetType returns the type of the event, one of the Event* constants.
+func (e *Event) GetType() int {
+	return e.typ
+}
+
+// GetChannelID returns the ID of the channel that the event belongs to.
+func (e *Event) GetChannelID() []byte {
+	return e.id[:]
+}
+
+// GetVersion returns the version of the channel state that the event refers
+// to. For on-chain events, this is the version that was registered.
+func (e *Event) GetVersion() int64 {
+	return int64(e.version)
+}
+
+// GetState returns the channel state of the event or nil if there is none.
+// Do not modify it.
+func (e *Event) GetState() *State {
+	if e.state == nil {
+		return nil
+	}
+	return &State{e.state}
+}
+
+// GetAmount returns the amount of a payment event or nil for other events.
+func (e *Event) GetAmount() *BigInt {
+	if e.amount == nil {
+		return nil
+	}
+	return &BigInt{e.amount}
+}
+
+// GetTxHash returns the hex encoded transaction hash of an EventTxReorged. It
+// is empty for other events.
+func (e *Event) GetTxHash() string {
+	return e.txHash
+}
+
+// GetError returns the error of an EventWatcherError. It is empty for other
+// events.
+func (e *Event) GetError() string {
+	if e.err == nil {
+		return ""
+	}
+	return e.err.Error()
+}
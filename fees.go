@@ -0,0 +1,129 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/pkg/errors"
+
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+)
+
+// Approximate upper bounds of the gas that the transactions of a two-party
+// ETH channel use, checked against measured receipts by TestFeeGasBounds. The
+// actual gas depends on the state of the contracts, so fees derived from them
+// are approximations.
+const (
+	depositGas  = ethchannel.ETHDepositorGasLimit
+	registerGas = 200000
+	concludeGas = 150000
+	withdrawGas = 100000
+)
+
+// FeeEstimate is a breakdown of the estimated fees of the transactions of a
+// channel at the current gas price. All fees are in Wei. They are
+// approximations: the gas price may change until the transactions are sent
+// and the gas that they use depends on the state of the contracts.
+type FeeEstimate struct {
+	gasPrice *big.Int
+}
+
+// EstimateFees estimates the fees of the on-chain transactions of a channel.
+// It can be called before ProposeChannel to estimate the costs of opening and
+// closing a channel. See PaymentChannel.EstimateSettleFee for an open
+// channel.
+func (c *Client) EstimateFees(ctx *Context) (*FeeEstimate, error) {
+	gasPrice, err := c.gasPrice(ctx.ctx)
+	if err != nil {
+		return nil, toError(err)
+	}
+	return &FeeEstimate{gasPrice: gasPrice}, nil
+}
+
+// EstimateSettleFee estimates the fees that Settle pays to settle the
+// channel in its current on-chain and off-chain state. See Settle for
+// `secondary`.
+func (c *PaymentChannel) EstimateSettleFee(ctx *Context, secondary bool) (*BigInt, error) {
+	gasPrice, err := c.c.gasPrice(ctx.ctx)
+	if err != nil {
+		return nil, toError(err)
+	}
+	status, err := c.c.onChainStatus(ctx.ctx, c.ch.ID())
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	var gas uint64 = withdrawGas
+	switch {
+	case status.phase == PhaseConcluded || secondary:
+		// Only the withdrawal is left or the peer concludes.
+	case status.registered || c.ch.State().IsFinal:
+		gas += concludeGas
+	default:
+		gas += registerGas + concludeGas
+	}
+	return &BigInt{fee(gasPrice, gas)}, nil
+}
+
+// gasPrice returns the gas price that the ETH node suggests.
+func (c *Client) gasPrice(ctx context.Context) (*big.Int, error) {
+	gasPrice, err := c.ethClient.SuggestGasPrice(ctx)
+	return gasPrice, errors.WithMessage(err, "querying gas price")
+}
+
+// fee returns the fee of `gas` at `gasPrice`.
+func fee(gasPrice *big.Int, gas uint64) *big.Int {
+	return new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(gas))
+}
+
+// GetGasPrice returns the gas price in Wei that the estimate is based on.
+func (f *FeeEstimate) GetGasPrice() *BigInt {
+	return &BigInt{new(big.Int).Set(f.gasPrice)}
+}
+
+// GetDepositFee returns the fee of funding a channel.
+func (f *FeeEstimate) GetDepositFee() *BigInt {
+	return &BigInt{fee(f.gasPrice, depositGas)}
+}
+
+// GetRegisterFee returns the fee of registering a state in a dispute.
+func (f *FeeEstimate) GetRegisterFee() *BigInt {
+	return &BigInt{fee(f.gasPrice, registerGas)}
+}
+
+// GetConcludeFee returns the fee of concluding a channel.
+func (f *FeeEstimate) GetConcludeFee() *BigInt {
+	return &BigInt{fee(f.gasPrice, concludeGas)}
+}
+
+// GetWithdrawFee returns the fee of withdrawing the funds of a channel.
+func (f *FeeEstimate) GetWithdrawFee() *BigInt {
+	return &BigInt{fee(f.gasPrice, withdrawGas)}
+}
+
+// GetOpenFee returns the fee of opening a channel, which is the deposit.
+func (f *FeeEstimate) GetOpenFee() *BigInt {
+	return f.GetDepositFee()
+}
+
+// GetCloseFee returns the fee of settling a finalized channel: concluding
+// and withdrawing.
+func (f *FeeEstimate) GetCloseFee() *BigInt {
+	return &BigInt{fee(f.gasPrice, concludeGas+withdrawGas)}
+}
+
+// GetDisputeCloseFee returns the fee of settling a channel that is not
+// finalized: registering, concluding and withdrawing.
+func (f *FeeEstimate) GetDisputeCloseFee() *BigInt {
+	return &BigInt{fee(f.gasPrice, registerGas+concludeGas+withdrawGas)}
+}
+
+// GetTotal returns the fee of opening and cooperatively closing a channel.
+func (f *FeeEstimate) GetTotal() *BigInt {
+	return &BigInt{fee(f.gasPrice, depositGas+concludeGas+withdrawGas)}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	ethkeystore "github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	_ "perun.network/go-perun/backend/ethereum" // backend init
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+	ethchanneltest "perun.network/go-perun/backend/ethereum/channel/test"
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/backend/ethereum/wallet/keystore"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/wallet"
+)
+
+// gasObserver records the highest gas used per transaction purpose.
+type gasObserver struct {
+	mutex sync.Mutex
+	used  map[int]uint64
+}
+
+func (o *gasObserver) OnTransaction(tx *Transaction) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if gas := uint64(tx.GetGasUsed()); tx.IsMined() && gas > o.used[tx.GetPurpose()] {
+		o.used[tx.GetPurpose()] = gas
+	}
+}
+
+// TestFeeGasBounds measures the gas of the transactions of a disputed
+// two-party channel and checks that the constants of the fee estimation
+// bound it.
+func TestFeeGasBounds(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	sb := ethchanneltest.NewSimulatedBackend()
+	sb.StartMining(10 * time.Millisecond)
+	defer sb.StopMining()
+	closed := make(chan struct{})
+	defer close(closed)
+	txs := newTxTracker(sb, 1, closed)
+	txs.pollInterval = 10 * time.Millisecond
+	gas := &gasObserver{used: make(map[int]uint64)}
+	txs.observer = gas
+
+	w, err := keystore.NewWallet(ethkeystore.NewKeyStore(t.TempDir(), ethkeystore.LightScryptN, ethkeystore.LightScryptP), "")
+	if err != nil {
+		t.Fatalf("creating wallet: %v", err)
+	}
+	accs := []*keystore.Account{w.NewAccount(), w.NewAccount()}
+	for _, acc := range accs {
+		sb.FundAddress(ctx, acc.Account.Address)
+	}
+	cb := ethchannel.NewContractBackend(txs, keystore.NewTransactor(*w, types.NewEIP155Signer(big.NewInt(1337))), 1)
+	adjAddr, err := ethchannel.DeployAdjudicator(ctx, cb, accs[0].Account)
+	if err != nil {
+		t.Fatalf("deploying adjudicator: %v", err)
+	}
+	holderAddr, err := ethchannel.DeployETHAssetholder(ctx, cb, adjAddr, accs[0].Account)
+	if err != nil {
+		t.Fatalf("deploying asset holder: %v", err)
+	}
+	asset := ethwallet.AsWalletAddr(holderAddr)
+
+	params, err := channel.NewParams(1, []wallet.Address{accs[0].Address(), accs[1].Address()}, channel.NoApp(), big.NewInt(1), true, false)
+	if err != nil {
+		t.Fatalf("creating params: %v", err)
+	}
+	state := &channel.State{
+		ID:      params.ID(),
+		Version: 1,
+		App:     channel.NoApp(),
+		Allocation: channel.Allocation{
+			Assets:   []channel.Asset{asset},
+			Balances: channel.Balances{{big.NewInt(1e15), big.NewInt(1e15)}},
+		},
+		Data: channel.NoData(),
+	}
+	tx := channel.Transaction{State: state}
+	for _, acc := range accs {
+		sig, err := channel.Sign(acc, state)
+		if err != nil {
+			t.Fatalf("signing state: %v", err)
+		}
+		tx.Sigs = append(tx.Sigs, sig)
+	}
+
+	// Both participants deposit concurrently since funding waits for all.
+	errs := make(chan error, len(accs))
+	for i, acc := range accs {
+		funder := ethchannel.NewFunder(cb)
+		funder.RegisterAsset(ethwallet.Address(holderAddr), new(ethchannel.ETHDepositor), acc.Account)
+		req := channel.FundingReq{Params: params, State: state, Idx: channel.Index(i), Agreement: state.Balances}
+		go func() { errs <- funder.Fund(ctx, req) }()
+	}
+	for range accs {
+		if err := <-errs; err != nil {
+			t.Fatalf("funding channel: %v", err)
+		}
+	}
+
+	// Register the non-final state, wait for the challenge duration and
+	// withdraw, which concludes the channel first.
+	adj := ethchannel.NewAdjudicator(cb, adjAddr, accs[0].Account.Address, accs[0].Account)
+	events, err := adj.Subscribe(ctx, params.ID())
+	if err != nil {
+		t.Fatalf("subscribing to adjudicator events: %v", err)
+	}
+	defer events.Close()
+	req := channel.AdjudicatorReq{Params: params, Acc: accs[0], Idx: 0, Tx: tx}
+	if err := adj.Register(ctx, req, nil); err != nil {
+		t.Fatalf("registering state: %v", err)
+	}
+	registered := events.Next()
+	if registered == nil {
+		t.Fatalf("no registered event: %v", events.Err())
+	}
+	if err := registered.Timeout().Wait(ctx); err != nil {
+		t.Fatalf("waiting for challenge duration: %v", err)
+	}
+	if err := adj.Withdraw(ctx, req, nil); err != nil {
+		t.Fatalf("withdrawing: %v", err)
+	}
+
+	// The receipts are reported asynchronously.
+	bounds := map[int]uint64{TxDeposit: depositGas, TxRegister: registerGas, TxConclude: concludeGas, TxWithdraw: withdrawGas}
+	for purpose, bound := range bounds {
+		var used uint64
+		for used == 0 && ctx.Err() == nil {
+			gas.mutex.Lock()
+			used = gas.used[purpose]
+			gas.mutex.Unlock()
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Logf("purpose %d: used %d gas, bound %d", purpose, used, bound)
+		if used == 0 || used > bound {
+			t.Errorf("purpose %d used %d gas, bound is %d", purpose, used, bound)
+		}
+	}
+}
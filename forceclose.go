@@ -0,0 +1,149 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"encoding/hex"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/log"
+)
+
+const (
+	// forceClosePrefix is the database prefix of the markers of force-closes
+	// in progress.
+	forceClosePrefix = "prnm/forceclose/"
+	// forceClosePollInterval is the maximal interval in which the on-chain
+	// status is checked while waiting for the challenge duration to elapse.
+	forceClosePollInterval = 30 * time.Second
+	// minForceCloseBackoff and maxForceCloseBackoff bound the waiting time
+	// between attempts of a resumed force-close.
+	minForceCloseBackoff = 5 * time.Second
+	maxForceCloseBackoff = 10 * time.Minute
+)
+
+// ForceCloseHandler is notified about the progress of a force-close.
+type ForceCloseHandler interface {
+	// OnForceCloseScheduled is called once the state with `version` is
+	// registered. The funds can be withdrawn at `completionTime`, a unix
+	// timestamp in seconds.
+	OnForceCloseScheduled(channelID []byte, version, completionTime int64)
+}
+
+// ForceClose closes the channel without the cooperation of the peer: it
+// registers the latest state, waits for the challenge duration to elapse and
+// withdraws the funds. `h` may be nil.
+// If persistence is enabled and the context expires or the app is killed
+// before the funds were withdrawn, the force-close is resumed in the
+// background once the channel is restored. Its progress is then reported to
+// the EventListener, ending with EventForceCloseCompleted.
+func (c *PaymentChannel) ForceClose(ctx *Context, h ForceCloseHandler) error {
+	if err := c.c.markForceClose(c, true); err != nil {
+		return newError(ErrorPersistence, "", errors.WithMessage(err, "storing force-close"))
+	}
+	return toError(c.forceClose(ctx.ctx, h))
+}
+
+// forceClose performs the force-close and removes its marker when done.
+func (c *PaymentChannel) forceClose(ctx context.Context, h ForceCloseHandler) error {
+	status, err := c.c.onChainStatus(ctx, c.ch.ID())
+	if err != nil {
+		return err
+	}
+	if !status.registered {
+		if err := c.ch.Register(ctx); err != nil {
+			return errors.WithMessage(err, "registering state")
+		}
+	}
+
+	for reported := false; ; reported = true {
+		if status, err = c.c.onChainStatus(ctx, c.ch.ID()); err != nil {
+			return err
+		}
+		if !reported && h != nil {
+			id := c.ch.ID()
+			h.OnForceCloseScheduled(id[:], int64(status.version), int64(status.timeout))
+		}
+		if status.IsConcludable() {
+			break
+		}
+		wait := time.Duration(status.GetRemainingSeconds()) * time.Second
+		if wait > forceClosePollInterval || wait == 0 {
+			wait = forceClosePollInterval
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "waiting for challenge duration")
+		case <-time.After(wait):
+		}
+	}
+
+	if err := c.ch.Settle(ctx, false); err != nil {
+		return errors.WithMessage(err, "withdrawing")
+	}
+	c.c.dropPayments(c.ch.ID())
+	c.c.events.emitState(EventChannelWithdrawn, c.ch.State())
+	if err := c.c.markForceClose(c, false); err != nil {
+		log.WithError(err).Warn("Removing force-close marker")
+	}
+	return nil
+}
+
+// markForceClose stores or removes the marker of a force-close of `pch` if
+// persistence is enabled.
+func (c *Client) markForceClose(pch *PaymentChannel, inProgress bool) error {
+	if c.db == nil {
+		return nil
+	}
+	id := pch.ch.ID()
+	key := forceClosePrefix + hex.EncodeToString(id[:])
+	if inProgress {
+		return c.db.Put(key, "")
+	}
+	return c.db.Delete(key)
+}
+
+// resumeForceClose resumes an interrupted force-close of `pch`, if there is
+// one. Failed attempts are retried with exponential backoff until the funds
+// are withdrawn or the Client is closed.
+func (c *Client) resumeForceClose(pch *PaymentChannel) {
+	if c.db == nil {
+		return
+	}
+	id := pch.ch.ID()
+	if ok, err := c.db.Has(forceClosePrefix + hex.EncodeToString(id[:])); err != nil || !ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	log.WithField("channel", id).Info("Resuming force-close")
+	for backoff := minForceCloseBackoff; ; backoff *= 2 {
+		err := pch.forceClose(ctx, nil)
+		if err == nil {
+			c.events.emit(&Event{typ: EventForceCloseCompleted, id: id, version: pch.ch.State().Version})
+			return
+		}
+		if backoff > maxForceCloseBackoff {
+			backoff = maxForceCloseBackoff
+		}
+		log.WithError(err).WithField("channel", id).Errorf("Resuming force-close, retrying in %v", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
@@ -0,0 +1,293 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package mdns implements the subset of multicast DNS service discovery
+// (RFC 6762, RFC 6763) that is needed to find peers on the local network.
+// Only IPv4 is supported.
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/log"
+)
+
+const (
+	// ttl is the time to live of advertised records, in seconds.
+	ttl = 120
+	// minQueryInterval and maxQueryInterval bound the interval in which
+	// Browse repeats its query.
+	minQueryInterval = time.Second
+	maxQueryInterval = time.Minute
+)
+
+// group is the mDNS multicast group.
+var group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+type (
+	// Service is an instance of a DNS-SD service.
+	Service struct {
+		Instance string            // Instance name, e.g. "alice"
+		Host     string            // Host name, e.g. "alice.local."
+		Port     uint16            // Port of the service.
+		IPs      []net.IP          // IPv4 addresses of the host.
+		Text     map[string]string // Key-value pairs of the TXT record.
+	}
+
+	// Responder advertises a service on the local network and answers
+	// queries for it.
+	Responder struct {
+		service string // Service type, e.g. "_perun._tcp.local."
+		records []record
+		conn    *net.UDPConn
+
+		closeOnce sync.Once
+		closed    chan struct{}
+	}
+)
+
+// Advertise announces `svc` as instance of the service type `service` and
+// answers queries until the responder is closed.
+func Advertise(service string, svc Service) (*Responder, error) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return nil, errors.Wrap(err, "joining mDNS group")
+	}
+	instance := svc.Instance + "." + service
+	r := &Responder{
+		service: service,
+		records: []record{
+			{name: service, typ: typePTR, ttl: ttl, target: instance},
+			{name: instance, typ: typeSRV, ttl: ttl, target: svc.Host, port: svc.Port},
+			{name: instance, typ: typeTXT, ttl: ttl, txt: encodeText(svc.Text)},
+		},
+		conn:   conn,
+		closed: make(chan struct{}),
+	}
+	for _, ip := range svc.IPs {
+		r.records = append(r.records, record{name: svc.Host, typ: typeA, ttl: ttl, ip: ip})
+	}
+
+	if err := r.announce(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go r.serve()
+	return r, nil
+}
+
+// Close stops answering queries and tells the network that the service is
+// gone.
+func (r *Responder) Close() error {
+	err := errors.New("responder already closed")
+	r.closeOnce.Do(func() {
+		close(r.closed)
+		// A TTL of zero withdraws the records. They are copied since serve
+		// might still announce the original records concurrently.
+		withdrawn := make([]record, len(r.records))
+		for i, rec := range r.records {
+			rec.ttl = 0
+			withdrawn[i] = rec
+		}
+		if err := r.send(withdrawn); err != nil {
+			log.WithError(err).Debug("Withdrawing mDNS records")
+		}
+		err = r.conn.Close()
+	})
+	return err
+}
+
+// serve answers queries for the service.
+func (r *Responder) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-r.closed:
+			default:
+				log.WithError(err).Warn("mDNS responder stopped")
+			}
+			return
+		}
+		m, err := parse(buf[:n])
+		if err != nil || m.isResponse() || !r.matches(m) {
+			continue
+		}
+		if err := r.announce(); err != nil {
+			log.WithError(err).Debug("Answering mDNS query")
+		}
+	}
+}
+
+// matches returns whether `m` asks for the service or the instance.
+func (r *Responder) matches(m *message) bool {
+	for _, q := range m.questions {
+		if q.typ != typePTR && q.typ != typeSRV && q.typ != typeTXT && q.typ != typeANY {
+			continue
+		}
+		for _, rec := range r.records {
+			if strings.EqualFold(q.name, rec.name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// announce sends all records to the multicast group.
+func (r *Responder) announce() error {
+	return r.send(r.records)
+}
+
+// send sends `records` to the multicast group.
+func (r *Responder) send(records []record) error {
+	m := message{flags: flagResponse, records: records}
+	buf, err := m.pack()
+	if err != nil {
+		return err
+	}
+	_, err = r.conn.WriteToUDP(buf, group)
+	return errors.Wrap(err, "sending mDNS response")
+}
+
+// Browse queries for instances of the service type `service` in the
+// background and calls `found` for every instance that is new or changed,
+// until `ctx` is done. It returns an error if browsing could not be started.
+// Otherwise, the returned channel receives the error that stopped browsing,
+// or nil once `ctx` is done, and is then closed.
+func Browse(ctx context.Context, service string, found func(Service)) (<-chan error, error) {
+	query, err := (&message{questions: []question{{name: service, typ: typePTR}}}).pack()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return nil, errors.Wrap(err, "joining mDNS group")
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	go func() {
+		for interval := minQueryInterval; ; interval *= 2 {
+			if _, err := conn.WriteToUDP(query, group); err != nil {
+				log.WithError(err).Debug("Sending mDNS query")
+			}
+			if interval > maxQueryInterval {
+				interval = maxQueryInterval
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- receive(ctx, conn, service, found)
+		close(done)
+	}()
+	return done, nil
+}
+
+// receive reads responses from `conn` and calls `found` for every instance of
+// `service` that is new or changed. It returns nil once `ctx` is done.
+func receive(ctx context.Context, conn *net.UDPConn, service string, found func(Service)) error {
+	known := make(map[string]string) // instance name -> address key
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			conn.Close()
+			return errors.Wrap(err, "receiving mDNS message")
+		}
+		m, err := parse(buf[:n])
+		if err != nil || !m.isResponse() {
+			continue
+		}
+		for _, svc := range services(m, service, src.IP) {
+			key := fmt.Sprintf("%s %v %d", svc.Host, svc.IPs, svc.Port)
+			if known[svc.Instance] == key {
+				continue
+			}
+			known[svc.Instance] = key
+			found(svc)
+		}
+	}
+}
+
+// services extracts the instances of `service` from `m`. If `m` contains no
+// address of an instance's host, the sender address `src` is used.
+func services(m *message, service string, src net.IP) []Service {
+	var svcs []Service
+	for _, ptr := range m.records {
+		if ptr.typ != typePTR || !strings.EqualFold(ptr.name, service) || ptr.ttl == 0 {
+			continue
+		}
+		svc := Service{Instance: strings.TrimSuffix(ptr.target, "."+service)}
+		var hasSRV bool
+		for _, r := range m.records {
+			switch {
+			case r.typ == typeSRV && strings.EqualFold(r.name, ptr.target):
+				svc.Host, svc.Port, hasSRV = r.target, r.port, true
+			case r.typ == typeTXT && strings.EqualFold(r.name, ptr.target):
+				svc.Text = decodeText(r.txt)
+			}
+		}
+		if !hasSRV {
+			continue
+		}
+		for _, r := range m.records {
+			if r.typ == typeA && strings.EqualFold(r.name, svc.Host) {
+				svc.IPs = append(svc.IPs, r.ip)
+			}
+		}
+		if len(svc.IPs) == 0 {
+			svc.IPs = []net.IP{src}
+		}
+		svcs = append(svcs, svc)
+	}
+	return svcs
+}
+
+// encodeText encodes key-value pairs as TXT record strings.
+func encodeText(text map[string]string) []string {
+	txt := make([]string, 0, len(text))
+	for k, v := range text {
+		txt = append(txt, k+"="+v)
+	}
+	if len(txt) == 0 {
+		// A TXT record must contain at least one string.
+		txt = append(txt, "")
+	}
+	return txt
+}
+
+// decodeText decodes TXT record strings into key-value pairs.
+func decodeText(txt []string) map[string]string {
+	text := make(map[string]string, len(txt))
+	for _, s := range txt {
+		if kv := strings.SplitN(s, "=", 2); len(kv) == 2 {
+			text[kv[0]] = kv[1]
+		} else if s != "" {
+			text[s] = ""
+		}
+	}
+	return text
+}
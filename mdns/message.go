@@ -0,0 +1,275 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package mdns
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Minimal sizes of a question and a resource record in wire format: a name
+// pointer or the root name, followed by the fixed fields.
+const (
+	minQuestionLen = 1 + 4
+	minRecordLen   = 1 + 10
+)
+
+// DNS record types and classes that are used for DNS-SD.
+const (
+	typeA   uint16 = 1
+	typePTR uint16 = 12
+	typeTXT uint16 = 16
+	typeSRV uint16 = 33
+	typeANY uint16 = 255
+
+	classIN uint16 = 1
+	// classCacheFlush marks records that are unique to the responder.
+	classCacheFlush uint16 = 0x8000
+
+	flagResponse uint16 = 0x8400 // QR and AA bit.
+)
+
+type (
+	// message is a DNS message. Records of the answer, authority and
+	// additional section are all collected in `records`.
+	message struct {
+		flags     uint16
+		questions []question
+		records   []record
+	}
+
+	question struct {
+		name string
+		typ  uint16
+	}
+
+	// record is a resource record. Depending on `typ`, the parsed fields are
+	// set.
+	record struct {
+		name string
+		typ  uint16
+		ttl  uint32
+
+		target string   // PTR and SRV
+		port   uint16   // SRV
+		txt    []string // TXT
+		ip     net.IP   // A
+	}
+)
+
+// isResponse returns whether `m` is a response.
+func (m *message) isResponse() bool {
+	return m.flags&0x8000 != 0
+}
+
+// pack encodes `m` in wire format. Names are not compressed.
+func (m *message) pack() ([]byte, error) {
+	buf := make([]byte, 12)
+	binary.BigEndian.PutUint16(buf[2:], m.flags)
+	binary.BigEndian.PutUint16(buf[4:], uint16(len(m.questions)))
+	binary.BigEndian.PutUint16(buf[6:], uint16(len(m.records)))
+
+	var err error
+	for _, q := range m.questions {
+		if buf, err = appendName(buf, q.name); err != nil {
+			return nil, err
+		}
+		buf = appendUint16(buf, q.typ)
+		buf = appendUint16(buf, classIN)
+	}
+	for _, r := range m.records {
+		if buf, err = r.pack(buf); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// pack appends the wire format of `r` to `buf`.
+func (r *record) pack(buf []byte) ([]byte, error) {
+	var data []byte
+	var err error
+	class := classIN
+	switch r.typ {
+	case typePTR:
+		data, err = appendName(nil, r.target)
+	case typeSRV:
+		class |= classCacheFlush
+		data = make([]byte, 6) // Priority and weight are zero.
+		binary.BigEndian.PutUint16(data[4:], r.port)
+		data, err = appendName(data, r.target)
+	case typeTXT:
+		class |= classCacheFlush
+		for _, s := range r.txt {
+			if len(s) > 255 {
+				return nil, errors.New("TXT string too long")
+			}
+			data = append(append(data, byte(len(s))), s...)
+		}
+	case typeA:
+		class |= classCacheFlush
+		data = r.ip.To4()
+		if data == nil {
+			return nil, errors.New("not an IPv4 address")
+		}
+	default:
+		return nil, errors.Errorf("unsupported record type %d", r.typ)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if buf, err = appendName(buf, r.name); err != nil {
+		return nil, err
+	}
+	buf = appendUint16(buf, r.typ)
+	buf = appendUint16(buf, class)
+	buf = append(buf, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(buf[len(buf)-4:], r.ttl)
+	buf = appendUint16(buf, uint16(len(data)))
+	return append(buf, data...), nil
+}
+
+// parse decodes a message in wire format. Records of unsupported types are
+// skipped.
+func parse(msg []byte) (*message, error) {
+	if len(msg) < 12 {
+		return nil, errors.New("message too short")
+	}
+	m := &message{flags: binary.BigEndian.Uint16(msg[2:])}
+	qdCount := int(binary.BigEndian.Uint16(msg[4:]))
+	rrCount := int(binary.BigEndian.Uint16(msg[6:])) +
+		int(binary.BigEndian.Uint16(msg[8:])) +
+		int(binary.BigEndian.Uint16(msg[10:]))
+	if qdCount*minQuestionLen+rrCount*minRecordLen > len(msg)-12 {
+		return nil, errors.New("section counts exceed message size")
+	}
+
+	off := 12
+	for i := 0; i < qdCount; i++ {
+		name, n, err := readName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n + 4
+		if off > len(msg) {
+			return nil, errors.New("question truncated")
+		}
+		m.questions = append(m.questions, question{name: name, typ: binary.BigEndian.Uint16(msg[n:])})
+	}
+	for i := 0; i < rrCount; i++ {
+		name, n, err := readName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		if n+10 > len(msg) {
+			return nil, errors.New("record truncated")
+		}
+		r := record{
+			name: name,
+			typ:  binary.BigEndian.Uint16(msg[n:]),
+			ttl:  binary.BigEndian.Uint32(msg[n+4:]),
+		}
+		start := n + 10
+		end := start + int(binary.BigEndian.Uint16(msg[n+8:]))
+		if end > len(msg) {
+			return nil, errors.New("record data truncated")
+		}
+		off = end
+		if err := r.parseData(msg, start, end); err != nil {
+			return nil, err
+		}
+		if r.typ == typePTR || r.typ == typeSRV || r.typ == typeTXT || r.typ == typeA {
+			m.records = append(m.records, r)
+		}
+	}
+	return m, nil
+}
+
+// parseData decodes the record data `msg[start:end]` of `r`.
+func (r *record) parseData(msg []byte, start, end int) (err error) {
+	data := msg[start:end]
+	switch r.typ {
+	case typePTR:
+		r.target, _, err = readName(msg, start)
+	case typeSRV:
+		if len(data) < 7 {
+			return errors.New("SRV record too short")
+		}
+		r.port = binary.BigEndian.Uint16(data[4:])
+		r.target, _, err = readName(msg, start+6)
+	case typeTXT:
+		for len(data) > 0 {
+			n := int(data[0])
+			if 1+n > len(data) {
+				return errors.New("TXT record truncated")
+			}
+			r.txt = append(r.txt, string(data[1:1+n]))
+			data = data[1+n:]
+		}
+	case typeA:
+		if len(data) != net.IPv4len {
+			return errors.New("malformed A record")
+		}
+		r.ip = net.IP(append([]byte(nil), data...))
+	}
+	return err
+}
+
+// readName reads the possibly compressed name at `off` in `msg`. It returns
+// the name and the offset behind it.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errors.New("name truncated")
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, errors.New("name pointer truncated")
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, errors.New("too many name pointers")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errors.New("label truncated")
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// appendName appends the uncompressed wire format of `name` to `buf`.
+func appendName(buf []byte, name string) ([]byte, error) {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, errors.Errorf("invalid name: %q", name)
+		}
+		buf = append(append(buf, byte(len(label))), label...)
+	}
+	return append(buf, 0), nil
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
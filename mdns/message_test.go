@@ -0,0 +1,93 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package mdns
+
+import (
+	"net"
+	"testing"
+)
+
+// header returns a message header with the given section counts.
+func header(qd, an byte) []byte {
+	return []byte{0, 0, 0x84, 0, 0, qd, 0, an, 0, 0, 0, 0}
+}
+
+func TestParse(t *testing.T) {
+	valid, err := (&message{
+		flags: flagResponse,
+		records: []record{
+			{name: "_perun._tcp.local.", typ: typePTR, ttl: ttl, target: "alice._perun._tcp.local."},
+			{name: "alice._perun._tcp.local.", typ: typeSRV, ttl: ttl, target: "alice.local.", port: 5750},
+			{name: "alice._perun._tcp.local.", typ: typeTXT, ttl: ttl, txt: []string{"id=alice"}},
+			{name: "alice.local.", typ: typeA, ttl: ttl, ip: net.IPv4(192, 168, 0, 2)},
+		},
+	}).pack()
+	if err != nil {
+		t.Fatalf("packing message: %v", err)
+	}
+	// A question whose name points to itself.
+	loop := append(header(1, 0), 0xc0, 12, 0, byte(typePTR), 0, 1)
+	// A record whose data claims more bytes than there are.
+	shortData := append(header(0, 1), 0, 0, byte(typeA), 0, 1, 0, 0, 0, 120, 0, 4, 192, 168)
+	// A TXT string that is longer than its record.
+	shortTXT := append(header(0, 1), 0, 0, byte(typeTXT), 0, 1, 0, 0, 0, 120, 0, 2, 5, 'a')
+
+	tests := []struct {
+		name  string
+		msg   []byte
+		valid bool
+	}{
+		{"valid", valid, true},
+		{"empty", nil, false},
+		{"short header", header(0, 0)[:11], false},
+		{"no sections", header(0, 0), true},
+		{"truncated", valid[:len(valid)-3], false},
+		{"truncated name", append(header(1, 0), 5, 'a', 'l'), false},
+		{"truncated pointer", append(header(1, 0), 0xc0), false},
+		{"pointer loop", loop, false},
+		{"oversized question count", append(header(255, 0), 0, 0, 1, 0, 1), false},
+		{"oversized record count", append(header(0, 255), valid[12:]...), false},
+		{"truncated record data", shortData, false},
+		{"truncated TXT string", shortTXT, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := parse(tt.msg)
+			if tt.valid && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.valid && (err == nil || m != nil) {
+				t.Fatalf("expected error, got %v", m)
+			}
+		})
+	}
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	buf, err := (&message{
+		flags: flagResponse,
+		records: []record{
+			{name: "alice._perun._tcp.local.", typ: typeSRV, ttl: ttl, target: "alice.local.", port: 5750},
+			{name: "alice._perun._tcp.local.", typ: typeTXT, ttl: ttl, txt: []string{"id=alice", "alias=Alice"}},
+		},
+	}).pack()
+	if err != nil {
+		t.Fatalf("packing message: %v", err)
+	}
+	m, err := parse(buf)
+	if err != nil {
+		t.Fatalf("parsing message: %v", err)
+	}
+	if !m.isResponse() || len(m.records) != 2 {
+		t.Fatalf("unexpected message: %+v", m)
+	}
+	if srv := m.records[0]; srv.target != "alice.local." || srv.port != 5750 {
+		t.Errorf("unexpected SRV record: %+v", srv)
+	}
+	if txt := decodeText(m.records[1].txt); txt["id"] != "alice" || txt["alias"] != "Alice" {
+		t.Errorf("unexpected TXT record: %v", txt)
+	}
+}
@@ -0,0 +1,378 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+	"perun.network/go-perun/log"
+)
+
+const (
+	// nonceCheckInterval is the interval in which the nonces of the account
+	// are checked for gaps.
+	nonceCheckInterval = 30 * time.Second
+	// transferGas is the gas of a plain ETH transfer.
+	transferGas = 21000
+	// maxNonceAttempts is the number of nonces that a transaction tries.
+	maxNonceAttempts = 3
+)
+
+type (
+	// nonceManager coordinates the nonces of all transactions of the on-chain
+	// account. The contract backend reserves the nonces: it hands out the
+	// maximum of the pending nonce of the node and the next nonce that it
+	// expects. The nonceManager never reports a pending nonce below the
+	// nonces that it sent. It fills nonces that were reserved but never sent,
+	// e.g. because the gas estimation failed, with empty transactions so that
+	// later transactions are not stuck. It replaces pending transactions with
+	// ones that pay higher fees. And if another wallet used the nonce of a
+	// transaction, it sends the transaction again with the next nonce of the
+	// node. The receipt of a transaction is that of whichever transaction of
+	// its replacements was mined.
+	nonceManager struct {
+		nonceBackend
+		tr     ethchannel.Transactor
+		acc    accounts.Account
+		signer types.Signer
+
+		mutex    sync.Mutex
+		next     uint64                        // nonce after the highest sent or reserved one
+		pending  map[uint64]*types.Transaction // sent and not yet mined, by nonce
+		gaps     map[uint64]bool               // unsent nonces at the last check
+		families map[common.Hash][]common.Hash // transaction → itself and its replacements, latest first
+	}
+
+	// nonceBackend is the backend of the nonceManager.
+	nonceBackend interface {
+		ethchannel.ContractInterface
+		NonceAt(ctx context.Context, account common.Address, number *big.Int) (uint64, error)
+	}
+)
+
+// newNonceManager returns a nonceManager for `acc` that sends over `backend`
+// and signs with `tr`.
+func newNonceManager(backend nonceBackend, tr ethchannel.Transactor, acc accounts.Account, signer types.Signer) *nonceManager {
+	return &nonceManager{
+		nonceBackend: backend,
+		tr:           tr,
+		acc:          acc,
+		signer:       signer,
+		pending:      make(map[uint64]*types.Transaction),
+		gaps:         make(map[uint64]bool),
+		families:     make(map[common.Hash][]common.Hash),
+	}
+}
+
+// PendingNonceAt returns the next nonce of `account`, which is at least the
+// nonce after the highest transaction that was sent.
+func (m *nonceManager) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	nonce, err := m.nonceBackend.PendingNonceAt(ctx, account)
+	if err != nil || account != m.acc.Address {
+		return nonce, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if nonce < m.next {
+		nonce = m.next
+	}
+	return nonce, nil
+}
+
+// SendTransaction sends `tx` and records it if it is sent by the account. If
+// the node reports that the nonce of `tx` was already used and neither `tx`
+// nor one of its replacements was mined, it is signed again with the next
+// nonce of the node and sent as replacement, up to maxNonceAttempts times.
+// The resynchronized nonce may collide with a transaction that is prepared
+// concurrently, which then is resent in the same way.
+func (m *nonceManager) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	if sender, err := types.Sender(m.signer, tx); err != nil || sender != m.acc.Address {
+		return m.nonceBackend.SendTransaction(ctx, tx)
+	}
+	for attempt := 1; ; attempt++ {
+		err := m.nonceBackend.SendTransaction(ctx, tx)
+		switch {
+		case err == nil, errors.Is(err, core.ErrAlreadyKnown):
+			m.record(tx)
+			return err
+		case !errors.Is(err, core.ErrNonceTooLow) || attempt == maxNonceAttempts:
+			return err
+		}
+		if receipt, rerr := m.TransactionReceipt(ctx, tx.Hash()); rerr == nil && receipt != nil {
+			return nil // tx or one of its replacements was mined.
+		}
+
+		nonce, err := m.resync(ctx)
+		if err != nil {
+			return err
+		}
+		log.WithField("nonce", tx.Nonce()).WithField("next", nonce).Warn("Nonce already used, resending transaction")
+		replacement, err := m.sign(withNonce(tx, nonce))
+		if err != nil {
+			return err
+		}
+		m.replaced(tx.Hash(), replacement.Hash())
+		tx = replacement
+	}
+}
+
+// TransactionReceipt returns the receipt of the transaction with `hash` or,
+// if it was replaced, the receipt of whichever of its replacements was mined.
+func (m *nonceManager) TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	m.mutex.Lock()
+	family, ok := m.families[hash]
+	m.mutex.Unlock()
+	if !ok {
+		family = []common.Hash{hash}
+	}
+	for _, h := range family {
+		receipt, err := m.nonceBackend.TransactionReceipt(ctx, h)
+		if err != nil && !errors.Is(err, ethereum.NotFound) {
+			return nil, err
+		}
+		if receipt != nil {
+			return receipt, nil
+		}
+	}
+	return nil, ethereum.NotFound
+}
+
+// record records the sent transaction `tx`.
+func (m *nonceManager) record(tx *types.Transaction) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.pending[tx.Nonce()] = tx
+	if tx.Nonce() >= m.next {
+		m.next = tx.Nonce() + 1
+	}
+}
+
+// resync updates the next nonce from the node and reserves it.
+func (m *nonceManager) resync(ctx context.Context) (uint64, error) {
+	nonce, err := m.nonceBackend.PendingNonceAt(ctx, m.acc.Address)
+	if err != nil {
+		return 0, errors.WithMessage(err, "querying pending nonce")
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if nonce < m.next {
+		nonce = m.next
+	}
+	m.next = nonce + 1
+	return nonce, nil
+}
+
+// replaced records that the transaction with `hash` is replaced by the
+// transaction with `replacement`.
+func (m *nonceManager) replaced(hash, replacement common.Hash) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	family, ok := m.families[hash]
+	if !ok {
+		family = []common.Hash{hash}
+	}
+	family = append([]common.Hash{replacement}, family...)
+	for _, h := range family {
+		m.families[h] = family
+	}
+}
+
+// run fills nonce gaps every nonceCheckInterval until `closed` is closed.
+func (m *nonceManager) run(closed <-chan struct{}) {
+	ticker := time.NewTicker(nonceCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), nonceCheckInterval)
+		if err := m.fillGaps(ctx); err != nil {
+			log.WithError(err).Warn("Checking nonces")
+		}
+		cancel()
+	}
+}
+
+// fillGaps forgets mined transactions and sends empty transactions for the
+// nonces below the highest sent one that were not sent. A nonce is only
+// filled if it was missing at the previous check already, so that
+// transactions that are being prepared are not overtaken.
+func (m *nonceManager) fillGaps(ctx context.Context) error {
+	mined, err := m.nonceBackend.NonceAt(ctx, m.acc.Address, nil)
+	if err != nil {
+		return errors.WithMessage(err, "querying nonce")
+	}
+	m.mutex.Lock()
+	for nonce := range m.pending {
+		if nonce < mined {
+			delete(m.pending, nonce)
+		}
+	}
+	var fill []uint64
+	gaps := make(map[uint64]bool)
+	for nonce := mined; nonce < m.next; nonce++ {
+		if _, ok := m.pending[nonce]; ok {
+			continue
+		}
+		if m.gaps[nonce] {
+			fill = append(fill, nonce)
+		} else {
+			gaps[nonce] = true
+		}
+	}
+	m.gaps = gaps
+	m.mutex.Unlock()
+
+	for _, nonce := range fill {
+		log.WithField("nonce", nonce).Warn("Filling unused nonce")
+		if err := m.fill(ctx, nonce); err != nil {
+			return errors.WithMessagef(err, "filling nonce %d", nonce)
+		}
+	}
+	return nil
+}
+
+// fill sends an empty transaction with `nonce`. It is not resent if the
+// nonce was used meanwhile.
+func (m *nonceManager) fill(ctx context.Context, nonce uint64) error {
+	gasPrice, err := m.nonceBackend.SuggestGasPrice(ctx)
+	if err != nil {
+		return errors.WithMessage(err, "querying gas price")
+	}
+	tx, err := m.sign(types.NewTransaction(nonce, m.acc.Address, new(big.Int), transferGas, gasPrice, nil))
+	if err != nil {
+		return err
+	}
+	if err := m.nonceBackend.SendTransaction(ctx, tx); err != nil {
+		return err
+	}
+	m.record(tx)
+	return nil
+}
+
+// replace replaces the pending transaction with `hash` by one that pays at
+// least 25% higher fees and returns the replacement.
+func (m *nonceManager) replace(ctx context.Context, hash common.Hash) (*types.Transaction, error) {
+	m.mutex.Lock()
+	var old *types.Transaction
+	for _, tx := range m.pending {
+		if tx.Hash() == hash {
+			old = tx
+		}
+	}
+	m.mutex.Unlock()
+	if old == nil {
+		return nil, errors.New("transaction is not pending")
+	}
+	if old.Type() != types.LegacyTxType {
+		return nil, errors.New("only legacy transactions can be replaced")
+	}
+
+	gasPrice, err := m.nonceBackend.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, errors.WithMessage(err, "querying gas price")
+	}
+	bumped := new(big.Int).Div(new(big.Int).Mul(old.GasPrice(), big.NewInt(125)), big.NewInt(100))
+	if gasPrice.Cmp(bumped) < 0 {
+		gasPrice = bumped
+	}
+	tx, err := m.sign(types.NewTx(&types.LegacyTx{
+		Nonce:    old.Nonce(),
+		GasPrice: gasPrice,
+		Gas:      old.Gas(),
+		To:       old.To(),
+		Value:    old.Value(),
+		Data:     old.Data(),
+	}))
+	if err != nil {
+		return nil, err
+	}
+	// Recorded before sending, so that SendTransaction finds the receipt of
+	// `old` if it is mined meanwhile.
+	m.replaced(hash, tx.Hash())
+	if err := m.SendTransaction(ctx, tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// sign signs `tx` with the account.
+func (m *nonceManager) sign(tx *types.Transaction) (*types.Transaction, error) {
+	opts, err := m.tr.NewTransactor(m.acc)
+	if err != nil {
+		return nil, errors.WithMessage(err, "creating transactor")
+	}
+	tx, err = opts.Signer(m.acc.Address, tx)
+	return tx, errors.WithMessage(err, "signing transaction")
+}
+
+// withNonce returns an unsigned copy of `tx` with `nonce`.
+func withNonce(tx *types.Transaction, nonce uint64) *types.Transaction {
+	switch tx.Type() {
+	case types.DynamicFeeTxType:
+		return types.NewTx(&types.DynamicFeeTx{
+			ChainID:    tx.ChainId(),
+			Nonce:      nonce,
+			GasTipCap:  tx.GasTipCap(),
+			GasFeeCap:  tx.GasFeeCap(),
+			Gas:        tx.Gas(),
+			To:         tx.To(),
+			Value:      tx.Value(),
+			Data:       tx.Data(),
+			AccessList: tx.AccessList(),
+		})
+	case types.AccessListTxType:
+		return types.NewTx(&types.AccessListTx{
+			ChainID:    tx.ChainId(),
+			Nonce:      nonce,
+			GasPrice:   tx.GasPrice(),
+			Gas:        tx.Gas(),
+			To:         tx.To(),
+			Value:      tx.Value(),
+			Data:       tx.Data(),
+			AccessList: tx.AccessList(),
+		})
+	}
+	return types.NewTx(&types.LegacyTx{
+		Nonce:    nonce,
+		GasPrice: tx.GasPrice(),
+		Gas:      tx.Gas(),
+		To:       tx.To(),
+		Value:    tx.Value(),
+		Data:     tx.Data(),
+	})
+}
+
+// SpeedUpTransaction replaces the pending transaction with the hex encoded
+// `hash` by one that pays at least 25% higher fees, or the current gas price
+// if it is higher. Operations that wait for the replaced transaction, like
+// Settle, complete once the replacement is mined. Returns the hash of the
+// replacement.
+func (c *Client) SpeedUpTransaction(ctx *Context, hash string) (string, error) {
+	h, err := hexutil.Decode(hash)
+	if err != nil || len(h) != common.HashLength {
+		return "", newError(ErrorInvalidArgument, "", errors.Errorf("invalid transaction hash %q", hash))
+	}
+	tx, err := c.nonces.replace(ctx.ctx, common.BytesToHash(h))
+	if err != nil {
+		return "", toError(errors.WithMessage(err, "replacing transaction"))
+	}
+	return tx.Hash().Hex(), nil
+}
@@ -0,0 +1,291 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+)
+
+type (
+	// testPool emulates the transaction pool of a node in front of a
+	// simulated backend, which only accepts transactions with the next
+	// nonce. All transactions must be sent by `from`.
+	testPool struct {
+		*backends.SimulatedBackend
+		from common.Address
+
+		mutex sync.Mutex
+		txs   map[uint64]*types.Transaction // by nonce
+	}
+
+	// keyTransactor is an ethchannel.Transactor that signs with `key`.
+	keyTransactor struct {
+		key *ecdsa.PrivateKey
+	}
+)
+
+// testNonceManager returns a nonceManager over a testPool with a funded
+// account and the key of that account.
+func testNonceManager(t *testing.T) (*nonceManager, *testPool, *ecdsa.PrivateKey) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	sim := backends.NewSimulatedBackend(core.GenesisAlloc{from: {Balance: big.NewInt(1e18)}}, 8_000_000)
+	t.Cleanup(func() { sim.Close() })
+	pool := &testPool{SimulatedBackend: sim, from: from, txs: make(map[uint64]*types.Transaction)}
+	signer := types.NewEIP155Signer(big.NewInt(1337))
+	return newNonceManager(pool, &keyTransactor{key: key}, accounts.Account{Address: from}, signer), pool, key
+}
+
+func (tr *keyTransactor) NewTransactor(accounts.Account) (*bind.TransactOpts, error) {
+	return bind.NewKeyedTransactorWithChainID(tr.key, big.NewInt(1337))
+}
+
+// PendingNonceAt returns the nonce after the pooled transactions.
+func (p *testPool) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	nonce, err := p.SimulatedBackend.PendingNonceAt(ctx, account)
+	if err != nil {
+		return 0, err
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for p.txs[nonce] != nil {
+		nonce++
+	}
+	return nonce, nil
+}
+
+// SuggestGasPrice returns the gas price of the test transactions. The
+// simulated backend suggests 1 Wei, which is below the base fee.
+func (p *testPool) SuggestGasPrice(context.Context) (*big.Int, error) {
+	return big.NewInt(1e10), nil
+}
+
+// SendTransaction adds `tx` to the pool. A pooled transaction is only
+// replaced by one with a higher gas price.
+func (p *testPool) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	mined, err := p.SimulatedBackend.PendingNonceAt(ctx, p.from)
+	if err != nil {
+		return err
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if tx.Nonce() < mined {
+		return core.ErrNonceTooLow
+	}
+	if old := p.txs[tx.Nonce()]; old != nil {
+		if old.Hash() == tx.Hash() {
+			return core.ErrAlreadyKnown
+		}
+		if tx.GasPrice().Cmp(old.GasPrice()) <= 0 {
+			return core.ErrReplaceUnderpriced
+		}
+	}
+	p.txs[tx.Nonce()] = tx
+	return nil
+}
+
+// mine mines the pooled transactions without gaps in a new block.
+func (p *testPool) mine(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+	for {
+		nonce, err := p.SimulatedBackend.PendingNonceAt(ctx, p.from)
+		if err != nil {
+			t.Fatalf("querying nonce: %v", err)
+		}
+		p.mutex.Lock()
+		tx := p.txs[nonce]
+		delete(p.txs, nonce)
+		p.mutex.Unlock()
+		if tx == nil {
+			break
+		}
+		if err := p.SimulatedBackend.SendTransaction(ctx, tx); err != nil {
+			t.Fatalf("mining transaction: %v", err)
+		}
+	}
+	p.Commit()
+}
+
+// mineTx mines `tx` instead of the pooled transaction with its nonce, like
+// another node that did not see the replacement.
+func (p *testPool) mineTx(t *testing.T, tx *types.Transaction) {
+	t.Helper()
+	p.mutex.Lock()
+	delete(p.txs, tx.Nonce())
+	p.mutex.Unlock()
+	if err := p.SimulatedBackend.SendTransaction(context.Background(), tx); err != nil {
+		t.Fatalf("mining transaction: %v", err)
+	}
+	p.Commit()
+}
+
+// send signs a transfer with `nonce` and sends it over `m`.
+func send(t *testing.T, m *nonceManager, nonce uint64) *types.Transaction {
+	t.Helper()
+	tx, err := m.sign(types.NewTransaction(nonce, common.Address{1}, big.NewInt(1), transferGas, big.NewInt(1e10), nil))
+	if err != nil {
+		t.Fatalf("signing transaction: %v", err)
+	}
+	if err := m.SendTransaction(context.Background(), tx); err != nil {
+		t.Fatalf("sending transaction: %v", err)
+	}
+	return tx
+}
+
+// receipt returns the receipt that `m` reports for `tx` or nil if it was not
+// mined.
+func receipt(t *testing.T, m *nonceManager, tx *types.Transaction) *types.Receipt {
+	t.Helper()
+	r, err := m.TransactionReceipt(context.Background(), tx.Hash())
+	if err != nil && !errors.Is(err, ethereum.NotFound) {
+		t.Fatalf("querying receipt: %v", err)
+	}
+	return r
+}
+
+func TestNonceManagerReservesConcurrently(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	m, pool, _ := testNonceManager(t)
+	cb := ethchannel.NewContractBackend(m, m.tr, 1)
+
+	const n = 10
+	sent := make(chan *types.Transaction, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			opts, err := cb.NewTransactor(ctx, transferGas, m.acc)
+			if err != nil {
+				t.Errorf("creating transactor: %v", err)
+				return
+			}
+			tx, err := opts.Signer(m.acc.Address, types.NewTransaction(opts.Nonce.Uint64(), common.Address{1}, big.NewInt(1), transferGas, big.NewInt(1e10), nil))
+			if err != nil {
+				t.Errorf("signing transaction: %v", err)
+				return
+			}
+			if err := m.SendTransaction(ctx, tx); err != nil {
+				t.Errorf("sending transaction: %v", err)
+				return
+			}
+			sent <- tx
+		}()
+	}
+	wg.Wait()
+	close(sent)
+	pool.mine(t)
+
+	nonces := make(map[uint64]bool)
+	for tx := range sent {
+		if nonces[tx.Nonce()] {
+			t.Errorf("nonce %d reserved twice", tx.Nonce())
+		}
+		nonces[tx.Nonce()] = true
+		if receipt(t, m, tx) == nil {
+			t.Errorf("transaction with nonce %d not mined", tx.Nonce())
+		}
+	}
+	if len(nonces) != n {
+		t.Fatalf("%d of %d transactions sent", len(nonces), n)
+	}
+}
+
+func TestNonceManagerFillsGaps(t *testing.T) {
+	ctx := context.Background()
+	m, pool, _ := testNonceManager(t)
+	// Nonce 0 was reserved but never sent.
+	tx := send(t, m, 1)
+	pool.mine(t)
+	if r := receipt(t, m, tx); r != nil {
+		t.Fatal("transaction after gap was mined")
+	}
+
+	// A gap is only filled at the second check.
+	for i := 0; i < 2; i++ {
+		if err := m.fillGaps(ctx); err != nil {
+			t.Fatalf("filling gaps: %v", err)
+		}
+	}
+	pool.mine(t)
+	if receipt(t, m, tx) == nil {
+		t.Fatal("transaction after filled gap was not mined")
+	}
+	if nonce, err := pool.NonceAt(ctx, m.acc.Address, nil); err != nil || nonce != 2 {
+		t.Fatalf("mined nonce is %d (%v), expected 2", nonce, err)
+	}
+}
+
+func TestNonceManagerReplacementMined(t *testing.T) {
+	m, pool, _ := testNonceManager(t)
+	tx := send(t, m, 0)
+	replacement, err := m.replace(context.Background(), tx.Hash())
+	if err != nil {
+		t.Fatalf("replacing transaction: %v", err)
+	}
+	pool.mine(t)
+	for _, h := range []*types.Transaction{tx, replacement} {
+		if r := receipt(t, m, h); r == nil || r.TxHash != replacement.Hash() {
+			t.Fatalf("receipt of %s is not that of the replacement: %v", h.Hash().Hex(), r)
+		}
+	}
+}
+
+func TestNonceManagerOriginalMined(t *testing.T) {
+	m, pool, _ := testNonceManager(t)
+	tx := send(t, m, 0)
+	replacement, err := m.replace(context.Background(), tx.Hash())
+	if err != nil {
+		t.Fatalf("replacing transaction: %v", err)
+	}
+	pool.mineTx(t, tx)
+	for _, h := range []*types.Transaction{tx, replacement} {
+		if r := receipt(t, m, h); r == nil || r.TxHash != tx.Hash() {
+			t.Fatalf("receipt of %s is not that of the original: %v", h.Hash().Hex(), r)
+		}
+	}
+}
+
+func TestNonceManagerResendsUsedNonce(t *testing.T) {
+	m, pool, key := testNonceManager(t)
+	// Another wallet of the same account uses nonce 0.
+	other, err := types.SignTx(
+		types.NewTransaction(0, common.Address{2}, big.NewInt(1), transferGas, big.NewInt(1e10), nil),
+		m.signer, key)
+	if err != nil {
+		t.Fatalf("signing transaction: %v", err)
+	}
+	pool.mineTx(t, other)
+
+	tx := send(t, m, 0)
+	pool.mine(t)
+	r := receipt(t, m, tx)
+	if r == nil || r.TxHash == other.Hash() || r.TxHash == tx.Hash() {
+		t.Fatalf("receipt is not that of the resent transaction: %v", r)
+	}
+}
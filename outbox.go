@@ -0,0 +1,501 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/pkg/sortedkv/leveldb"
+	"perun.network/go-perun/wire"
+)
+
+// Status of a queued payment as reported to the OutboxHandler.
+const (
+	// PaymentQueued means that the payment was stored and waits for delivery.
+	PaymentQueued = iota
+	// PaymentSent means that the payment was accepted by the peer.
+	PaymentSent
+	// PaymentFailed means that the peer was reachable but the payment could
+	// not be made, e.g. because it was rejected, or that it is unknown
+	// whether a payment that was being sent when the app stopped was made.
+	// It is not retried.
+	PaymentFailed
+)
+
+const (
+	// outboxPrefix is the database prefix of queued payments. The keys are
+	// outboxPrefix + hex(channel ID) + "/" + zero padded payment ID, the
+	// values are the 8 byte version of the payment, the 32 byte amount and
+	// the expected balance.
+	outboxPrefix = "prnm/outbox/"
+	// outboxSeqKey stores the last assigned payment ID.
+	outboxSeqKey = "prnm/outbox-seq"
+	// outboxSendTimeout limits the time that a single delivery may take.
+	outboxSendTimeout = 30 * time.Second
+)
+
+type (
+	// OutboxHandler is notified about status changes of queued payments.
+	OutboxHandler interface {
+		// OnPaymentStatus is called with one of PaymentQueued, PaymentSent
+		// or PaymentFailed. `reason` is only set for failed payments.
+		OnPaymentStatus(channelID []byte, paymentID int64, status int, reason string)
+	}
+
+	// outbox stores payments durably and delivers them in order per channel
+	// whenever the peer is reachable.
+	outbox struct {
+		db *leveldb.Database
+		h  OutboxHandler
+
+		mutex  sync.Mutex
+		seq    int64
+		queues map[channel.ID]*paymentQueue
+	}
+
+	// outboxChannel is a channel that the outbox delivers payments on. It is
+	// implemented by PaymentChannel.
+	outboxChannel interface {
+		// state returns the current state of the channel and our index in it.
+		state() (*channel.State, channel.Index)
+		// connect connects to the peer, if there is no connection.
+		connect(context.Context) error
+		// update proposes `next`, which pays `amount` to the peer.
+		update(ctx context.Context, next *channel.State, amount *big.Int) error
+	}
+
+	// paymentQueue holds the pending payments of a channel.
+	paymentQueue struct {
+		pending  []*queuedPayment // in order of delivery
+		running  bool             // whether a delivery routine is running
+		inFlight int64            // ID of the payment being sent, 0 if none
+	}
+
+	queuedPayment struct {
+		id     int64
+		amount *big.Int
+		// version and balance are the channel version that the payment
+		// produces and our balance in it. They are stored before sending, so
+		// that a payment whose update was applied before a crash is not sent
+		// again. version is 0 if the payment is not being sent.
+		version uint64
+		balance *big.Int
+	}
+)
+
+// EnableOutbox enables queueing of payments with PaymentChannel.Queue.
+// Queued payments are stored in the database of EnablePersistence, so
+// persistence must be enabled first. Payments that are still pending from a
+// previous run are delivered once their channels are restored.
+// `h` may be nil.
+// This function is not thread safe.
+func (c *Client) EnableOutbox(h OutboxHandler) error {
+	if c.db == nil {
+		return newError(ErrorPersistence, "", errors.New("persistence not enabled"))
+	}
+	o := &outbox{db: c.db, h: h, queues: make(map[channel.ID]*paymentQueue)}
+	if err := o.load(); err != nil {
+		return newError(ErrorPersistence, "", errors.WithMessage(err, "loading outbox"))
+	}
+	c.channelsMutex.Lock()
+	c.outbox = o
+	c.channelsMutex.Unlock()
+
+	for id := range o.queues {
+		if pch := c.trackedChannel(id); pch != nil {
+			o.deliver(pch)
+		}
+	}
+	return nil
+}
+
+// Queue stores a payment of `amount` to the counterparty and returns its
+// payment ID. The payment is delivered as soon as the peer is reachable,
+// after all payments that were queued before on this channel. The outbox
+// must be enabled with Client.EnableOutbox.
+func (c *PaymentChannel) Queue(amount *BigInt) (int64, error) {
+	o := c.c.getOutbox()
+	if o == nil {
+		return 0, toError(errors.New("outbox not enabled"))
+	}
+	if amount.i.Sign() < 1 {
+		return 0, newError(ErrorInvalidArgument, "", errors.New("Only positive amounts supported in send"))
+	}
+	if amount.i.BitLen() > 256 {
+		return 0, newError(ErrorInvalidArgument, "", errors.New("amount exceeds 256 bits"))
+	}
+	id, err := o.add(c.ch.ID(), new(big.Int).Set(amount.i))
+	if err != nil {
+		return 0, toError(err)
+	}
+	o.notify(c.ch.ID(), id, PaymentQueued, "")
+	o.deliver(c)
+	return id, nil
+}
+
+// CancelPayment removes the pending payment `paymentID` from the outbox.
+// It fails if the payment is not pending anymore or currently being sent.
+func (c *PaymentChannel) CancelPayment(paymentID int64) error {
+	o := c.c.getOutbox()
+	if o == nil {
+		return toError(errors.New("outbox not enabled"))
+	}
+	return toError(o.cancel(c.ch.ID(), paymentID))
+}
+
+// PendingPayments returns the number of payments that wait for delivery on
+// this channel.
+func (c *PaymentChannel) PendingPayments() int {
+	o := c.c.getOutbox()
+	if o == nil {
+		return 0
+	}
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if q, ok := o.queues[c.ch.ID()]; ok {
+		return len(q.pending)
+	}
+	return 0
+}
+
+// getOutbox returns the outbox or nil if it is not enabled.
+func (c *Client) getOutbox() *outbox {
+	c.channelsMutex.Lock()
+	defer c.channelsMutex.Unlock()
+	return c.outbox
+}
+
+// dropPayments drops the queued payments of channel `id` since it was
+// settled or closed.
+func (c *Client) dropPayments(id channel.ID) {
+	if o := c.getOutbox(); o != nil {
+		o.drop(id)
+	}
+}
+
+// deliverTo starts the delivery of pending payments on all channels with
+// `peer`.
+func (c *Client) deliverTo(peer wire.Address) {
+	o := c.getOutbox()
+	if o == nil {
+		return
+	}
+	c.channelsMutex.Lock()
+	var chs []*PaymentChannel
+	for _, pch := range c.channels {
+		if pch.peer().Equal(peer) {
+			chs = append(chs, pch)
+		}
+	}
+	c.channelsMutex.Unlock()
+	for _, pch := range chs {
+		o.deliver(pch)
+	}
+}
+
+// load reads the pending payments from the database.
+func (o *outbox) load() error {
+	if seq, err := o.db.Get(outboxSeqKey); err == nil {
+		if o.seq, err = strconv.ParseInt(seq, 10, 64); err != nil {
+			return errors.Wrap(err, "parsing payment ID")
+		}
+	}
+
+	it := o.db.NewIteratorWithPrefix(outboxPrefix)
+	defer it.Close()
+	for it.Next() {
+		id, paymentID, err := parseOutboxKey(it.Key())
+		if err != nil {
+			return err
+		}
+		p, err := decodePayment(paymentID, it.ValueBytes())
+		if err != nil {
+			return err
+		}
+		q := o.queue(id)
+		q.pending = append(q.pending, p)
+	}
+	for _, q := range o.queues {
+		sort.Slice(q.pending, func(i, j int) bool { return q.pending[i].id < q.pending[j].id })
+	}
+	return nil
+}
+
+// add stores a new payment and returns its ID.
+func (o *outbox) add(id channel.ID, amount *big.Int) (int64, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	paymentID := o.seq + 1
+	if err := o.db.Put(outboxSeqKey, strconv.FormatInt(paymentID, 10)); err != nil {
+		return 0, errors.WithMessage(err, "storing payment ID")
+	}
+	p := &queuedPayment{id: paymentID, amount: amount}
+	if err := o.db.PutBytes(outboxKey(id, paymentID), p.encode()); err != nil {
+		return 0, errors.WithMessage(err, "storing payment")
+	}
+	o.seq = paymentID
+	q := o.queue(id)
+	q.pending = append(q.pending, p)
+	return paymentID, nil
+}
+
+// cancel removes the pending payment `paymentID` of channel `id`.
+func (o *outbox) cancel(id channel.ID, paymentID int64) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	q, ok := o.queues[id]
+	if !ok {
+		return errors.Errorf("payment %d not pending", paymentID)
+	}
+	if q.inFlight == paymentID {
+		return errors.Errorf("payment %d is being sent", paymentID)
+	}
+	for i, p := range q.pending {
+		if p.id == paymentID {
+			if err := o.db.Delete(outboxKey(id, paymentID)); err != nil {
+				return errors.WithMessage(err, "deleting payment")
+			}
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return nil
+		}
+	}
+	return errors.Errorf("payment %d not pending", paymentID)
+}
+
+// drop removes all pending payments of channel `id` since it was closed or
+// settled. They are reported as failed.
+func (o *outbox) drop(id channel.ID) {
+	o.mutex.Lock()
+	q, ok := o.queues[id]
+	if !ok {
+		o.mutex.Unlock()
+		return
+	}
+	delete(o.queues, id)
+	dropped := q.pending
+	q.pending = nil
+	for _, p := range dropped {
+		if err := o.db.Delete(outboxKey(id, p.id)); err != nil {
+			log.WithError(err).WithField("payment", p.id).Error("Deleting dropped payment")
+		}
+	}
+	o.mutex.Unlock()
+	for _, p := range dropped {
+		o.notify(id, p.id, PaymentFailed, "channel closed")
+	}
+}
+
+// deliver starts delivering the pending payments of `ch`, unless they are
+// already being delivered.
+func (o *outbox) deliver(ch outboxChannel) {
+	state, _ := ch.state()
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	q, ok := o.queues[state.ID]
+	if !ok || q.running || len(q.pending) == 0 {
+		return
+	}
+	q.running = true
+	go o.run(ch, state.ID, q)
+}
+
+// run sends the pending payments of channel `id` in order. It returns when
+// the queue is empty or the peer is unreachable. In the latter case,
+// delivery is resumed once the peer connects again.
+func (o *outbox) run(ch outboxChannel, id channel.ID, q *paymentQueue) {
+	for {
+		o.mutex.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			o.mutex.Unlock()
+			return
+		}
+		p := q.pending[0]
+		q.inFlight = p.id
+		o.mutex.Unlock()
+
+		err := o.attempt(ch, id, p)
+		if errors.Is(err, errPeerUnreachable) {
+			log.WithError(err).WithField("payment", p.id).Debug("Delaying queued payment")
+			o.mutex.Lock()
+			q.inFlight, q.running = 0, false
+			o.mutex.Unlock()
+			return
+		}
+
+		o.mutex.Lock()
+		if len(q.pending) == 0 || q.pending[0] != p {
+			// The queue was dropped in the meantime.
+			q.inFlight, q.running = 0, false
+			o.mutex.Unlock()
+			return
+		}
+		if err := o.db.Delete(outboxKey(id, p.id)); err != nil {
+			log.WithError(err).WithField("payment", p.id).Error("Deleting delivered payment")
+		}
+		q.pending, q.inFlight = q.pending[1:], 0
+		o.mutex.Unlock()
+		if err != nil {
+			o.notify(id, p.id, PaymentFailed, err.Error())
+		} else {
+			o.notify(id, p.id, PaymentSent, "")
+		}
+	}
+}
+
+var (
+	// errPeerUnreachable is returned by send if the peer could not be
+	// reached.
+	errPeerUnreachable = errors.New("peer unreachable")
+	// errDeliveryUnknown is returned by attempt if a payment was being sent
+	// before a restart and later updates hide whether it was applied.
+	errDeliveryUnknown = errors.New("delivery unknown after restart, check the channel balance")
+)
+
+// attempt delivers `p` on channel `id`. The version and our balance of the
+// next state are stored before sending, so that after a restart, it can be
+// checked whether the update was applied before. Otherwise, the payment
+// only counts as delivered if the update succeeded.
+func (o *outbox) attempt(ch outboxChannel, id channel.ID, p *queuedPayment) error {
+	state, idx := ch.state()
+	if p.version != 0 {
+		switch {
+		case state.Version == p.version && state.Allocation.Balances[0][idx].Cmp(p.balance) == 0:
+			log.WithField("payment", p.id).Debug("Queued payment already delivered")
+			return nil
+		case state.Version > p.version:
+			return errDeliveryUnknown
+		}
+		// Another update or none has the version, so the payment is sent
+		// again.
+	}
+	next := paymentState(state, idx, p.amount)
+	if next.Allocation.Balances[0][idx].Sign() < 0 {
+		return errors.New("insufficient channel balance")
+	}
+	if err := o.setExpected(id, p, next.Version, next.Allocation.Balances[0][idx]); err != nil {
+		return err
+	}
+
+	err := o.send(ch, next, p.amount)
+	if errors.Is(err, errPeerUnreachable) {
+		// The update was not applied, so the next attempt starts from the
+		// state at that time.
+		if err := o.setExpected(id, p, 0, nil); err != nil {
+			log.WithError(err).WithField("payment", p.id).Error("Resetting payment version")
+		}
+	}
+	return err
+}
+
+// setExpected stores `version` and `balance` as the expected channel version
+// and our balance after `p`, which must be in flight.
+func (o *outbox) setExpected(id channel.ID, p *queuedPayment, version uint64, balance *big.Int) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if q, ok := o.queues[id]; !ok || len(q.pending) == 0 || q.pending[0] != p {
+		return errors.Errorf("payment %d dropped", p.id)
+	}
+	p.version, p.balance = version, balance
+	return errors.WithMessage(o.db.PutBytes(outboxKey(id, p.id), p.encode()), "storing payment version")
+}
+
+// send proposes `next`, which pays `amount`, on `ch`. If the peer cannot be
+// connected or did not respond in time, errPeerUnreachable is returned since
+// the payment can be retried. Other errors, like rejections, are returned
+// as they are.
+func (o *outbox) send(ch outboxChannel, next *channel.State, amount *big.Int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), outboxSendTimeout)
+	defer cancel()
+	if err := ch.connect(ctx); err != nil {
+		return errors.WithMessage(errPeerUnreachable, err.Error())
+	}
+	err := ch.update(ctx, next, amount)
+	var clientErr *ClientError
+	if err != nil && (ctx.Err() != nil || errors.As(toError(err), &clientErr) && clientErr.code == ErrorPeerUnreachable) {
+		return errors.WithMessage(errPeerUnreachable, err.Error())
+	}
+	return err
+}
+
+// notify reports a status change to the OutboxHandler.
+func (o *outbox) notify(id channel.ID, paymentID int64, status int, reason string) {
+	if o.h != nil {
+		o.h.OnPaymentStatus(id[:], paymentID, status, reason)
+	}
+}
+
+// queue returns the queue of channel `id` and creates it if needed. The
+// mutex must be held.
+func (o *outbox) queue(id channel.ID) *paymentQueue {
+	q, ok := o.queues[id]
+	if !ok {
+		q = new(paymentQueue)
+		o.queues[id] = q
+	}
+	return q
+}
+
+// encode returns the database value of `p`.
+func (p *queuedPayment) encode() []byte {
+	buf := make([]byte, 8+32)
+	binary.BigEndian.PutUint64(buf, p.version)
+	p.amount.FillBytes(buf[8:])
+	if p.version != 0 {
+		buf = append(buf, p.balance.Bytes()...)
+	}
+	return buf
+}
+
+// decodePayment decodes the database value `value` of payment `paymentID`.
+func decodePayment(paymentID int64, value []byte) (*queuedPayment, error) {
+	if len(value) < 8+32 {
+		return nil, errors.Errorf("malformed payment %d", paymentID)
+	}
+	p := &queuedPayment{
+		id:      paymentID,
+		version: binary.BigEndian.Uint64(value),
+		amount:  new(big.Int).SetBytes(value[8 : 8+32]),
+	}
+	if p.version != 0 {
+		p.balance = new(big.Int).SetBytes(value[8+32:])
+	}
+	return p, nil
+}
+
+// outboxKey returns the database key of a queued payment. The payment ID is
+// zero padded so that the keys of a channel are sorted by ID.
+func outboxKey(id channel.ID, paymentID int64) string {
+	return fmt.Sprintf("%s%s/%020d", outboxPrefix, hex.EncodeToString(id[:]), paymentID)
+}
+
+// parseOutboxKey parses a key that was created by outboxKey.
+func parseOutboxKey(key string) (id channel.ID, paymentID int64, err error) {
+	parts := strings.Split(strings.TrimPrefix(key, outboxPrefix), "/")
+	if len(parts) != 2 {
+		return id, 0, errors.Errorf("malformed outbox key: %q", key)
+	}
+	b, err := hex.DecodeString(parts[0])
+	if err != nil || len(b) != len(id) {
+		return id, 0, errors.Errorf("malformed channel ID in outbox key: %q", key)
+	}
+	copy(id[:], b)
+	paymentID, err = strconv.ParseInt(parts[1], 10, 64)
+	return id, paymentID, errors.Wrapf(err, "malformed payment ID in outbox key: %q", key)
+}
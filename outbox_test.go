@@ -0,0 +1,240 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/pkg/sortedkv/leveldb"
+)
+
+type (
+	// fakeChannel is an outboxChannel whose peer accepts every update with
+	// the next version, unless onUpdate is set. We are participant 0.
+	fakeChannel struct {
+		mutex   sync.Mutex
+		cur     *channel.State
+		updates int
+		// onUpdate handles the proposal of `next` instead, if set. It is
+		// called with the mutex held.
+		onUpdate func(next *channel.State) error
+	}
+
+	// paymentStatus is a status change of a queued payment.
+	paymentStatus struct {
+		id     int64
+		status int
+		reason string
+	}
+
+	// statusRecorder is an OutboxHandler that passes all status changes to
+	// a channel.
+	statusRecorder chan paymentStatus
+)
+
+// testChannelID is the channel ID of all fake channels.
+var testChannelID = channel.ID{1}
+
+// newFakeChannel returns a fakeChannel at `version` with balances `mine` and
+// `theirs`.
+func newFakeChannel(version uint64, mine, theirs int64) *fakeChannel {
+	return &fakeChannel{cur: &channel.State{
+		ID:      testChannelID,
+		Version: version,
+		Allocation: channel.Allocation{
+			Balances: channel.Balances{{big.NewInt(mine), big.NewInt(theirs)}},
+		},
+		Data: channel.NoData(),
+	}}
+}
+
+func (f *fakeChannel) state() (*channel.State, channel.Index) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.cur.Clone(), 0
+}
+
+func (f *fakeChannel) connect(context.Context) error { return nil }
+
+func (f *fakeChannel) update(_ context.Context, next *channel.State, _ *big.Int) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.updates++
+	if f.onUpdate != nil {
+		return f.onUpdate(next)
+	}
+	if next.Version != f.cur.Version+1 {
+		return errors.Errorf("invalid version %d, current is %d", next.Version, f.cur.Version)
+	}
+	f.cur = next.Clone()
+	return nil
+}
+
+// check fails `t` unless the channel is at `version` with balances `mine`
+// and `theirs` after `updates` updates.
+func (f *fakeChannel) check(t *testing.T, updates int, version uint64, mine, theirs int64) {
+	t.Helper()
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	bals := f.cur.Allocation.Balances[0]
+	if f.updates != updates || f.cur.Version != version || bals[0].Int64() != mine || bals[1].Int64() != theirs {
+		t.Fatalf("channel is at version %d with balances %v after %d updates, expected version %d with [%d %d] after %d",
+			f.cur.Version, bals, f.updates, version, mine, theirs, updates)
+	}
+}
+
+func (r statusRecorder) OnPaymentStatus(_ []byte, paymentID int64, status int, reason string) {
+	r <- paymentStatus{id: paymentID, status: status, reason: reason}
+}
+
+// await returns the next status change other than PaymentQueued.
+func (r statusRecorder) await(t *testing.T) paymentStatus {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case s := <-r:
+			if s.status != PaymentQueued {
+				return s
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for payment status")
+		}
+	}
+}
+
+// loadOutbox returns an outbox over `db` with the payments stored in it.
+func loadOutbox(t *testing.T, db *leveldb.Database, h OutboxHandler) *outbox {
+	t.Helper()
+	o := &outbox{db: db, h: h, queues: make(map[channel.ID]*paymentQueue)}
+	if err := o.load(); err != nil {
+		t.Fatalf("loading outbox: %v", err)
+	}
+	return o
+}
+
+// pending returns the number of pending payments of the test channel.
+func (o *outbox) pending() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if q, ok := o.queues[testChannelID]; ok {
+		return len(q.pending)
+	}
+	return 0
+}
+
+func TestOutboxDelivers(t *testing.T) {
+	db, err := leveldb.LoadDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer db.Close()
+	rec := make(statusRecorder, 10)
+	o := loadOutbox(t, db, rec)
+	ch := newFakeChannel(1, 100, 100)
+
+	for i := int64(1); i <= 2; i++ {
+		id, err := o.add(testChannelID, big.NewInt(10))
+		if err != nil {
+			t.Fatalf("adding payment: %v", err)
+		}
+		o.deliver(ch)
+		if s := rec.await(t); s.id != id || s.status != PaymentSent {
+			t.Fatalf("payment %d has status %+v, expected sent", id, s)
+		}
+	}
+	ch.check(t, 2, 3, 80, 120)
+	if n := loadOutbox(t, db, nil).pending(); n != 0 {
+		t.Fatalf("%d delivered payments still stored", n)
+	}
+}
+
+// TestOutboxRestart checks the delivery of a payment that was being sent
+// when the app was stopped. The payment of 10 was expected to produce
+// version 2 with our balance 90.
+func TestOutboxRestart(t *testing.T) {
+	tests := []struct {
+		name string
+		ch   *fakeChannel
+		// status, updates, version and balances after the delivery.
+		status       int
+		updates      int
+		version      uint64
+		mine, theirs int64
+	}{
+		{"applied", newFakeChannel(2, 90, 110), PaymentSent, 0, 2, 90, 110},
+		{"not applied", newFakeChannel(1, 100, 100), PaymentSent, 1, 2, 90, 110},
+		// The peer paid us 20 in version 2 instead.
+		{"version taken", newFakeChannel(2, 120, 80), PaymentSent, 1, 3, 110, 90},
+		{"version hidden", newFakeChannel(3, 80, 120), PaymentFailed, 0, 3, 80, 120},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := leveldb.LoadDatabase(t.TempDir())
+			if err != nil {
+				t.Fatalf("opening database: %v", err)
+			}
+			defer db.Close()
+			before := loadOutbox(t, db, nil)
+			id, err := before.add(testChannelID, big.NewInt(10))
+			if err != nil {
+				t.Fatalf("adding payment: %v", err)
+			}
+			p := before.queues[testChannelID].pending[0]
+			if err := before.setExpected(testChannelID, p, 2, big.NewInt(90)); err != nil {
+				t.Fatalf("storing expected state: %v", err)
+			}
+
+			rec := make(statusRecorder, 10)
+			o := loadOutbox(t, db, rec)
+			o.deliver(tt.ch)
+			if s := rec.await(t); s.id != id || s.status != tt.status {
+				t.Fatalf("payment has status %+v, expected %d", s, tt.status)
+			}
+			tt.ch.check(t, tt.updates, tt.version, tt.mine, tt.theirs)
+			if n := loadOutbox(t, db, nil).pending(); n != 0 {
+				t.Fatalf("%d finished payments still stored", n)
+			}
+		})
+	}
+}
+
+// TestOutboxConcurrentUpdate checks that a rejected payment is not reported
+// as sent when another update reaches its version.
+func TestOutboxConcurrentUpdate(t *testing.T) {
+	db, err := leveldb.LoadDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer db.Close()
+	rec := make(statusRecorder, 10)
+	o := loadOutbox(t, db, rec)
+	ch := newFakeChannel(1, 100, 100)
+	// The peer rejects our update since its own payment of 20 took the
+	// version.
+	ch.onUpdate = func(next *channel.State) error {
+		ch.cur.Version = next.Version
+		ch.cur.Allocation.Balances[0] = []channel.Bal{big.NewInt(120), big.NewInt(80)}
+		return errors.New("rejected")
+	}
+
+	id, err := o.add(testChannelID, big.NewInt(10))
+	if err != nil {
+		t.Fatalf("adding payment: %v", err)
+	}
+	o.deliver(ch)
+	if s := rec.await(t); s.id != id || s.status != PaymentFailed {
+		t.Fatalf("payment has status %+v, expected failed", s)
+	}
+	ch.check(t, 1, 2, 120, 80)
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+)
+
+// defaultProposalTimeout is used if Config.ProposalTimeout is not set.
+const defaultProposalTimeout = 30 * time.Second
+
+type (
+	// ChallengeDurationError is returned when a channel is proposed with a
+	// challenge duration outside of Config.MinChallengeDuration and
+	// Config.MaxChallengeDuration. Incoming proposals that violate these
+	// bounds are rejected with its message as the reason.
+	ChallengeDurationError struct {
+		Duration, Min, Max int64 // in seconds, zero bounds are not enforced
+	}
+
+	// ProposalTimeoutError is returned when the peer did not respond to a
+	// channel proposal within Config.ProposalTimeout.
+	ProposalTimeoutError struct {
+		Timeout int64 // in seconds
+	}
+
+	// FundingTimeoutError is returned when the funding of a new channel did
+	// not complete within Config.FundingTimeout.
+	FundingTimeoutError struct {
+		Timeout int64 // in seconds
+		err     error // returned by the funder
+	}
+
+	// proposalTimer cancels the context of a channel proposal when the
+	// proposal timeout expires. It is stopped once the funding starts, so
+	// that the timeout only bounds the message exchange.
+	proposalTimer struct {
+		timer   *time.Timer
+		expired int32 // atomic, 1 if the timer canceled the context
+	}
+
+	// proposalTimerKey is the context key of the proposalTimer.
+	proposalTimerKey struct{}
+
+	// timeoutFunder is a channel.Funder that bounds the funding by its own
+	// timeout instead of the context of the proposal, so that callers do not
+	// need to keep the context alive until the funding completes.
+	timeoutFunder struct {
+		channel.Funder
+		timeout time.Duration // twice the challenge duration if zero
+		closed  <-chan struct{}
+	}
+)
+
+func (e *ChallengeDurationError) Error() string {
+	switch {
+	case e.Min > 0 && e.Duration < e.Min:
+		return fmt.Sprintf("challenge duration %ds below minimum of %ds", e.Duration, e.Min)
+	default:
+		return fmt.Sprintf("challenge duration %ds above maximum of %ds", e.Duration, e.Max)
+	}
+}
+
+func (e *ProposalTimeoutError) Error() string {
+	return fmt.Sprintf("peer did not respond within %ds", e.Timeout)
+}
+
+func (e *FundingTimeoutError) Error() string {
+	return fmt.Sprintf("funding did not complete within %ds: %v", e.Timeout, e.err)
+}
+
+// Cause returns the error of the funder, so that go-perun still recognizes
+// it.
+func (e *FundingTimeoutError) Cause() error { return e.err }
+
+// Unwrap returns the error of the funder.
+func (e *FundingTimeoutError) Unwrap() error { return e.err }
+
+// checkChallengeDuration returns a ChallengeDurationError if `duration`
+// violates the bounds of the Config.
+func (c *Client) checkChallengeDuration(duration int64) error {
+	min, max := c.cfg.MinChallengeDuration, c.cfg.MaxChallengeDuration
+	if (min > 0 && duration < min) || (max > 0 && duration > max) {
+		return &ChallengeDurationError{Duration: duration, Min: min, Max: max}
+	}
+	return nil
+}
+
+// proposalTimeout returns the configured proposal timeout.
+func (c *Client) proposalTimeout() time.Duration {
+	if c.cfg.ProposalTimeout <= 0 {
+		return defaultProposalTimeout
+	}
+	return time.Duration(c.cfg.ProposalTimeout) * time.Second
+}
+
+// withProposalTimeout returns a context for a channel proposal that is
+// canceled when the proposal timeout expires before the funding starts. Use
+// proposalError to convert its errors.
+func (c *Client) withProposalTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	t := new(proposalTimer)
+	t.timer = time.AfterFunc(c.proposalTimeout(), func() {
+		atomic.StoreInt32(&t.expired, 1)
+		cancel()
+	})
+	return context.WithValue(ctx, proposalTimerKey{}, t), func() {
+		t.timer.Stop()
+		cancel()
+	}
+}
+
+// stopProposalTimer stops the proposal timer of `ctx`, if any.
+func stopProposalTimer(ctx context.Context) {
+	if t, ok := ctx.Value(proposalTimerKey{}).(*proposalTimer); ok {
+		t.timer.Stop()
+	}
+}
+
+// proposalExpired returns whether the proposal timer of `ctx` expired.
+func proposalExpired(ctx context.Context) bool {
+	t, ok := ctx.Value(proposalTimerKey{}).(*proposalTimer)
+	return ok && atomic.LoadInt32(&t.expired) == 1
+}
+
+// proposalError returns a ProposalTimeoutError instead of `err` if the
+// proposal context `pctx` timed out before its parent `ctx`. Funding
+// timeouts are returned unchanged.
+func (c *Client) proposalError(ctx, pctx context.Context, err error) error {
+	var fundingErr *FundingTimeoutError
+	if errors.As(err, &fundingErr) || channel.IsFundingTimeoutError(err) {
+		return err
+	}
+	if ctx.Err() == nil && proposalExpired(pctx) {
+		return &ProposalTimeoutError{Timeout: int64(c.proposalTimeout() / time.Second)}
+	}
+	return err
+}
+
+// Fund funds the channel of `req` within the timeout. It stops the proposal
+// timer of `ctx` and is only canceled early if the Client is closed.
+func (f *timeoutFunder) Fund(ctx context.Context, req channel.FundingReq) error {
+	stopProposalTimer(ctx)
+	timeout := f.timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Duration(req.Params.ChallengeDuration) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-f.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := f.Funder.Fund(ctx, req)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return &FundingTimeoutError{Timeout: int64(timeout / time.Second), err: err}
+	}
+	return err
+}
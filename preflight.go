@@ -0,0 +1,72 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/wire"
+)
+
+// preflight checks that a channel with `peer` can be opened before it is
+// proposed or accepted: the contracts must be deployed and linked, the peer
+// must be reachable and our on-chain balance must cover `deposit` plus the
+// fees of the deposit transaction. The errors are ClientErrors.
+func (c *Client) preflight(ctx context.Context, peer wire.Address, deposit *big.Int) error {
+	if err := c.checkContracts(ctx); err != nil {
+		return newError(ErrorInvalidContracts, "", err)
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	if err := c.connect(dialCtx, peer); err != nil {
+		return newError(ErrorPeerUnreachable, "", errors.WithMessage(err, "connecting to peer"))
+	}
+	return c.checkFunds(ctx, deposit)
+}
+
+// checkContracts checks that the Adjudicator and AssetHolder have the
+// expected code and that the AssetHolder belongs to the Adjudicator.
+func (c *Client) checkContracts(ctx context.Context) error {
+	adj, holder := common.Address(c.cfg.Adjudicator.addr), common.Address(c.cfg.AssetHolder.addr)
+	if err := ethchannel.ValidateAdjudicator(ctx, c.ethClient, adj); err != nil {
+		return errors.WithMessagef(err, "validating adjudicator at %s", adj.Hex())
+	}
+	if err := ethchannel.ValidateAssetHolderETH(ctx, c.ethClient, holder, adj); err != nil {
+		return errors.WithMessagef(err, "validating asset holder at %s", holder.Hex())
+	}
+	return nil
+}
+
+// checkFunds checks that our on-chain balance covers `deposit` plus the
+// fees of the deposit transaction at the current gas price.
+func (c *Client) checkFunds(ctx context.Context, deposit *big.Int) error {
+	if deposit.Sign() == 0 {
+		return nil // No deposit transaction is sent.
+	}
+	gasPrice, err := c.gasPrice(ctx)
+	if err != nil {
+		return toError(err)
+	}
+	addr := common.Address(*c.onChain.Address().(*ethwallet.Address))
+	bal, err := c.ethClient.BalanceAt(ctx, addr, nil)
+	if err != nil {
+		return toError(errors.WithMessage(err, "querying balance"))
+	}
+	need := fee(gasPrice, depositGas)
+	need.Add(need, deposit)
+	if bal.Cmp(need) < 0 {
+		details := fmt.Sprintf("need %v wei, have %v wei", need, bal)
+		return newError(ErrorInsufficientFunds, details, errors.New("insufficient funds for deposit: "+details))
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"encoding/hex"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/pkg/sortedkv/leveldb"
+	"perun.network/go-perun/wallet"
+)
+
+const (
+	// receiverPrefix is the database prefix of the withdrawal receivers of
+	// channels. The values are hex encoded addresses.
+	receiverPrefix = "prnm/receiver/"
+	// receiverPartPrefix is the database prefix of the withdrawal receivers
+	// that are set for our participant address before a channel is opened.
+	receiverPartPrefix = "prnm/receiver-part/"
+)
+
+// receiverAdjudicator is an Adjudicator that withdraws the funds of every
+// channel to its own receiver. Channels without a receiver are withdrawn to
+// the receiver of the embedded Adjudicator.
+type receiverAdjudicator struct {
+	*ethchannel.Adjudicator
+	cb       ethchannel.ContractBackend
+	contract common.Address
+	acc      accounts.Account
+
+	mutex        sync.Mutex
+	db           *leveldb.Database                          // nil if persistence is not enabled
+	receivers    map[string]common.Address                  // by database key
+	adjudicators map[common.Address]*ethchannel.Adjudicator // per receiver
+}
+
+// newReceiverAdjudicator returns a receiverAdjudicator for the Adjudicator at
+// `contract` that withdraws to `acc` by default.
+func newReceiverAdjudicator(cb ethchannel.ContractBackend, contract common.Address, acc accounts.Account) *receiverAdjudicator {
+	return &receiverAdjudicator{
+		Adjudicator:  ethchannel.NewAdjudicator(cb, contract, acc.Address, acc),
+		cb:           cb,
+		contract:     contract,
+		acc:          acc,
+		receivers:    make(map[string]common.Address),
+		adjudicators: make(map[common.Address]*ethchannel.Adjudicator),
+	}
+}
+
+// SetWithdrawalReceiver sets the address that the funds of the channel are
+// withdrawn to. The receiver must be an externally owned account, e.g. a cold
+// wallet, and is stored if persistence is enabled.
+func (c *PaymentChannel) SetWithdrawalReceiver(ctx *Context, receiver *Address) error {
+	addr := common.Address(receiver.addr)
+	if err := c.c.validateReceiver(ctx.ctx, addr); err != nil {
+		return err
+	}
+	id := c.ch.ID()
+	if err := c.c.adjudicator.setReceiver(receiverPrefix+hex.EncodeToString(id[:]), addr); err != nil {
+		return newError(ErrorPersistence, "", err)
+	}
+	return nil
+}
+
+// GetWithdrawalReceiver returns the address that the funds of the channel
+// are withdrawn to.
+func (c *PaymentChannel) GetWithdrawalReceiver() *Address {
+	return &Address{ethwallet.Address(c.c.adjudicator.receiver(c.ch.Params(), c.ch.Idx()))}
+}
+
+// SettleTo settles the channel like Settle, but withdraws the funds to
+// `receiver`.
+func (c *PaymentChannel) SettleTo(ctx *Context, secondary bool, receiver *Address) error {
+	if err := c.SetWithdrawalReceiver(ctx, receiver); err != nil {
+		return err
+	}
+	return toError(c.Settle(ctx, secondary))
+}
+
+// validateReceiver checks that `addr` can receive withdrawn funds. Invalid
+// receivers are reported as ErrorInvalidArgument.
+func (c *Client) validateReceiver(ctx context.Context, addr common.Address) error {
+	switch addr {
+	case common.Address{}:
+		return newError(ErrorInvalidArgument, "", errors.New("receiver must not be the zero address"))
+	case common.Address(c.cfg.Adjudicator.addr), common.Address(c.cfg.AssetHolder.addr):
+		return newError(ErrorInvalidArgument, "", errors.New("receiver must not be a channel contract"))
+	}
+	code, err := c.ethClient.CodeAt(ctx, addr, nil)
+	if err != nil {
+		return toError(errors.WithMessage(err, "querying receiver code"))
+	}
+	if len(code) != 0 {
+		return newError(ErrorInvalidArgument, "", errors.New("receiver must be an externally owned account"))
+	}
+	return nil
+}
+
+// enablePersistence makes the receivers persistent in `db`.
+func (a *receiverAdjudicator) enablePersistence(db *leveldb.Database) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.db = db
+}
+
+// setPartReceiver sets and stores the receiver of the channel that will be
+// opened with our participant address `part`.
+func (a *receiverAdjudicator) setPartReceiver(part wallet.Address, receiver common.Address) error {
+	return a.setReceiver(receiverPartPrefix+ethwallet.AsEthAddr(part).Hex(), receiver)
+}
+
+// setReceiver sets and stores the receiver under the database key `key`.
+func (a *receiverAdjudicator) setReceiver(key string, receiver common.Address) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.db != nil {
+		if err := a.db.Put(key, receiver.Hex()); err != nil {
+			return errors.WithMessage(err, "storing receiver")
+		}
+	}
+	a.receivers[key] = receiver
+	return nil
+}
+
+// receiver returns the receiver of the channel with `params`, in which we
+// have index `idx`. A receiver of the channel takes precedence over one that
+// was set for our participant address before the channel was opened.
+func (a *receiverAdjudicator) receiver(params *channel.Params, idx channel.Index) common.Address {
+	id := params.ID()
+	if r, ok := a.lookup(receiverPrefix + hex.EncodeToString(id[:])); ok {
+		return r
+	}
+	if r, ok := a.lookup(receiverPartPrefix + ethwallet.AsEthAddr(params.Parts[idx]).Hex()); ok {
+		return r
+	}
+	return a.Adjudicator.Receiver
+}
+
+// lookup returns the receiver under the database key `key`, if any.
+func (a *receiverAdjudicator) lookup(key string) (common.Address, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if r, ok := a.receivers[key]; ok {
+		return r, true
+	}
+	if a.db != nil {
+		if r, err := a.db.Get(key); err == nil && common.IsHexAddress(r) {
+			a.receivers[key] = common.HexToAddress(r)
+			return a.receivers[key], true
+		}
+	}
+	return common.Address{}, false
+}
+
+// Withdraw withdraws the funds of the channel of `req` to its receiver.
+func (a *receiverAdjudicator) Withdraw(ctx context.Context, req channel.AdjudicatorReq, subStates channel.StateMap) error {
+	receiver := a.receiver(req.Params, req.Idx)
+	if receiver == a.Adjudicator.Receiver {
+		return a.Adjudicator.Withdraw(ctx, req, subStates)
+	}
+	log.WithField("receiver", receiver.Hex()).Info("Withdrawing to receiver")
+	a.mutex.Lock()
+	adj, ok := a.adjudicators[receiver]
+	if !ok {
+		adj = ethchannel.NewAdjudicator(a.cb, a.contract, receiver, a.acc)
+		a.adjudicators[receiver] = adj
+	}
+	a.mutex.Unlock()
+	return adj.Withdraw(ctx, req, subStates)
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package relay
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/log"
+)
+
+const (
+	// minBackoff and maxBackoff bound the waiting time between attempts to
+	// re-register at the relay.
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+type (
+	// Signer signs `hash` with the Perun ID of a peer.
+	Signer func(hash []byte) ([]byte, error)
+
+	// Listener accepts sessions that are relayed to a Perun ID. It keeps the
+	// control connection to the relay alive and registers again after the
+	// connection was lost.
+	Listener struct {
+		relay  string
+		id     common.Address
+		sign   Signer
+		dialer net.Dialer
+
+		conns     chan net.Conn
+		closed    chan struct{}
+		closeOnce sync.Once
+
+		mutex   sync.Mutex
+		control net.Conn // current control connection
+	}
+)
+
+// Listen registers the Perun ID `id` at the relay with address `relay`.
+// The registration is proven with a signature by `sign`.
+func Listen(ctx context.Context, relay string, id common.Address, sign Signer) (*Listener, error) {
+	l := &Listener{
+		relay:  relay,
+		id:     id,
+		sign:   sign,
+		dialer: net.Dialer{Timeout: handshakeTimeout},
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	control, err := l.register(ctx)
+	if err != nil {
+		return nil, err
+	}
+	go l.run(control)
+	return l, nil
+}
+
+// Accept returns the next relayed session.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errors.New("listener closed")
+	}
+}
+
+// Close unregisters from the relay. Already accepted sessions stay open.
+func (l *Listener) Close() error {
+	err := errors.New("listener already closed")
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		err = l.control.Close()
+	})
+	return err
+}
+
+// Dial opens a session to the peer with Perun ID `id` through the relay with
+// address `relay`.
+func Dial(ctx context.Context, relay string, id common.Address) (net.Conn, error) {
+	conn, _, err := connect(ctx, &net.Dialer{}, relay)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFrame(conn, msgDial, id.Bytes()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The relay answers once the peer accepted the session.
+	deadline := time.Now().Add(handshakeTimeout + sessionTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	if _, err := expectFrame(conn, msgOK, 0); err != nil {
+		conn.Close()
+		return nil, errors.WithMessagef(err, "dialing %s", id.Hex())
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// run serves the control connection and registers again with exponential
+// backoff once it is lost, until the listener is closed.
+func (l *Listener) run(control net.Conn) {
+	for {
+		l.serve(control)
+		for backoff := minBackoff; ; backoff *= 2 {
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			select {
+			case <-l.closed:
+				return
+			case <-time.After(backoff):
+			}
+			var err error
+			if control, err = l.register(context.Background()); err == nil {
+				break
+			}
+			log.WithError(err).Warn("Registering at relay")
+		}
+	}
+}
+
+// serve pings the control connection and accepts the announced sessions
+// until the connection fails.
+func (l *Listener) serve(control net.Conn) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if writeFrame(control, msgPing, nil) != nil {
+					control.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		control.SetReadDeadline(time.Now().Add(2 * pingInterval))
+		typ, payload, err := readFrame(control)
+		if err != nil {
+			log.WithError(err).Debug("Lost relay control connection")
+			control.Close()
+			return
+		}
+		if typ == msgIncoming && len(payload) == tokenLen {
+			go l.acceptSession(payload)
+		}
+	}
+}
+
+// register opens a control connection and registers it under the Perun ID.
+func (l *Listener) register(ctx context.Context) (net.Conn, error) {
+	conn, nonce, err := connect(ctx, &l.dialer, l.relay)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := l.sign(ChallengeHash(nonce))
+	if err != nil {
+		conn.Close()
+		return nil, errors.WithMessage(err, "signing challenge")
+	}
+	if err := writeFrame(conn, msgRegister, append(l.id.Bytes(), sig...)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := expectFrame(conn, msgOK, 0); err != nil {
+		conn.Close()
+		return nil, errors.WithMessage(err, "registering")
+	}
+	conn.SetDeadline(time.Time{})
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	select {
+	case <-l.closed:
+		conn.Close()
+		return nil, errors.New("listener closed")
+	default:
+	}
+	l.control = conn
+	return conn, nil
+}
+
+// acceptSession opens the connection for the session `token` and hands it to
+// Accept.
+func (l *Listener) acceptSession(token []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), sessionTimeout)
+	defer cancel()
+	conn, _, err := connect(ctx, &l.dialer, l.relay)
+	if err != nil {
+		log.WithError(err).Warn("Accepting relayed session")
+		return
+	}
+	if err := writeFrame(conn, msgAccept, token); err != nil {
+		conn.Close()
+		return
+	}
+	if _, err := expectFrame(conn, msgOK, 0); err != nil {
+		log.WithError(err).Warn("Accepting relayed session")
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+// connect connects to the relay and reads its challenge. The deadline of the
+// returned connection is set to the handshake timeout or the deadline of
+// `ctx`, whichever is earlier.
+func connect(ctx context.Context, dialer *net.Dialer, relay string) (net.Conn, []byte, error) {
+	conn, err := dialer.DialContext(ctx, "tcp", relay)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "connecting to relay %s", relay)
+	}
+	deadline := time.Now().Add(handshakeTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	nonce, err := expectFrame(conn, msgChallenge, challengeLen)
+	if err != nil {
+		conn.Close()
+		return nil, nil, errors.WithMessage(err, "reading challenge")
+	}
+	return conn, nonce, nil
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package relay implements a rendezvous relay for peers that can not accept
+// incoming connections, e.g. phones behind NAT.
+//
+// Every peer keeps an outbound control connection to the relay that is
+// registered under its Perun ID. To reach a peer, a dialer opens a new
+// connection to the relay and names the Perun ID. The relay notifies the
+// peer over its control connection, the peer opens a connection for the
+// session and the relay forwards all data between both connections.
+// The relay only authenticates registrations. A dialer can name any Perun ID
+// and the relay itself could answer a session in place of either peer, so
+// sessions must be authenticated end-to-end on top of the relay, e.g. by TLS
+// with certificates bound to the Perun IDs of both peers.
+package relay
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+)
+
+// Frame types of the relay protocol. Every frame consists of a one byte type,
+// a two byte big endian payload length and the payload.
+const (
+	// msgChallenge is sent by the relay on every new connection. Payload:
+	// a random nonce of challengeLen bytes.
+	msgChallenge byte = iota + 1
+	// msgRegister registers a control connection. Payload: Perun ID followed
+	// by its signature over ChallengeHash(nonce).
+	msgRegister
+	// msgDial requests a session to a registered peer. Payload: Perun ID.
+	msgDial
+	// msgIncoming notifies a registered peer of a session. Payload: token.
+	msgIncoming
+	// msgAccept accepts a session. Payload: token.
+	msgAccept
+	// msgOK confirms the previous request. Empty payload.
+	msgOK
+	// msgError rejects the previous request. Payload: reason.
+	msgError
+	// msgPing keeps control connections alive. Empty payload.
+	msgPing
+)
+
+const (
+	challengeLen = 32
+	tokenLen     = 16
+	sigLen       = 65
+	// challengePrefix is prepended to the nonce before signing it.
+	challengePrefix = "perun-eth-mobile relay registration"
+)
+
+// ChallengeHash returns the hash that a peer signs with its Perun ID to
+// register at the relay.
+func ChallengeHash(nonce []byte) []byte {
+	return crypto.Keccak256([]byte(challengePrefix), nonce)
+}
+
+// writeFrame writes a frame of type `typ` with payload `payload` to `w`.
+func writeFrame(w io.Writer, typ byte, payload []byte) error {
+	if len(payload) > 0xffff {
+		return errors.New("payload too long")
+	}
+	frame := make([]byte, 3+len(payload))
+	frame[0] = typ
+	binary.BigEndian.PutUint16(frame[1:3], uint16(len(payload)))
+	copy(frame[3:], payload)
+	_, err := w.Write(frame)
+	return errors.Wrap(err, "writing frame")
+}
+
+// readFrame reads exactly one frame from `r`. Nothing is read past the frame,
+// so that `r` can be used as raw connection afterwards.
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var header [3]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, errors.Wrap(err, "reading frame header")
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[1:3]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, errors.Wrap(err, "reading frame payload")
+	}
+	return header[0], payload, nil
+}
+
+// expectFrame reads a frame from `r` and checks that it has type `typ` and a
+// payload of length `n`. An msgError frame is returned as error.
+func expectFrame(r io.Reader, typ byte, n int) ([]byte, error) {
+	t, payload, err := readFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case t == msgError:
+		return nil, errors.Errorf("relay: %s", payload)
+	case t != typ:
+		return nil, errors.Errorf("unexpected frame type %d", t)
+	case len(payload) != n:
+		return nil, errors.Errorf("unexpected payload length %d", len(payload))
+	}
+	return payload, nil
+}
+
+// recoverID returns the Perun ID that signed the challenge `nonce`.
+func recoverID(nonce, sig []byte) (common.Address, error) {
+	pub, err := crypto.SigToPub(ChallengeHash(nonce), sig)
+	if err != nil {
+		return common.Address{}, errors.WithMessage(err, "recovering public key")
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
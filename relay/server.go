@@ -0,0 +1,266 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package relay
+
+import (
+	"crypto/rand"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/log"
+)
+
+const (
+	// handshakeTimeout limits the time that a connection may take to send its
+	// request after connecting.
+	handshakeTimeout = 15 * time.Second
+	// sessionTimeout limits the time that a registered peer may take to
+	// accept a session.
+	sessionTimeout = 15 * time.Second
+	// pingInterval is the interval in which peers ping their control
+	// connection. The relay drops control connections that were silent for
+	// two intervals.
+	pingInterval = 30 * time.Second
+)
+
+type (
+	// Server is a relay server. It forwards sessions between dialers and peers
+	// that are registered under their Perun IDs.
+	Server struct {
+		mutex   sync.Mutex
+		peers   map[common.Address]*control
+		pending map[[tokenLen]byte]chan net.Conn
+	}
+
+	// control is the control connection of a registered peer.
+	control struct {
+		conn  net.Conn
+		mutex sync.Mutex // serializes writes
+	}
+)
+
+// NewServer returns a new relay server.
+func NewServer() *Server {
+	return &Server{
+		peers:   make(map[common.Address]*control),
+		pending: make(map[[tokenLen]byte]chan net.Conn),
+	}
+}
+
+// Serve accepts connections on `l` and handles them. It returns when `l` is
+// closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return errors.Wrap(err, "accepting connection")
+		}
+		go s.handle(conn)
+	}
+}
+
+// NumPeers returns the number of registered peers.
+func (s *Server) NumPeers() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.peers)
+}
+
+// handle challenges a new connection and dispatches its request.
+func (s *Server) handle(conn net.Conn) {
+	nonce := make([]byte, challengeLen)
+	if _, err := rand.Read(nonce); err != nil {
+		log.WithError(err).Error("Generating challenge")
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := writeFrame(conn, msgChallenge, nonce); err != nil {
+		conn.Close()
+		return
+	}
+	typ, payload, err := readFrame(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	switch typ {
+	case msgRegister:
+		s.register(conn, nonce, payload)
+	case msgDial:
+		s.dial(conn, payload)
+	case msgAccept:
+		s.accept(conn, payload)
+	default:
+		reject(conn, "unexpected request")
+	}
+}
+
+// register registers `conn` as control connection of the Perun ID that
+// signed `nonce`. An existing control connection of the same ID is replaced.
+func (s *Server) register(conn net.Conn, nonce, payload []byte) {
+	if len(payload) != common.AddressLength+sigLen {
+		reject(conn, "malformed registration")
+		return
+	}
+	id := common.BytesToAddress(payload[:common.AddressLength])
+	if signer, err := recoverID(nonce, payload[common.AddressLength:]); err != nil || signer != id {
+		reject(conn, "invalid signature")
+		return
+	}
+
+	c := &control{conn: conn}
+	s.mutex.Lock()
+	if old, ok := s.peers[id]; ok {
+		old.conn.Close()
+	}
+	s.peers[id] = c
+	s.mutex.Unlock()
+	log.WithField("peer", id.Hex()).Debug("Registered peer")
+
+	if err := c.write(msgOK, nil); err != nil {
+		s.unregister(id, c)
+		return
+	}
+	// The control connection is kept alive by pings, which are answered.
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+		typ, _, err := readFrame(conn)
+		if err != nil || typ != msgPing || c.write(msgPing, nil) != nil {
+			s.unregister(id, c)
+			return
+		}
+	}
+}
+
+// unregister removes the control connection `c` of `id` and closes it.
+func (s *Server) unregister(id common.Address, c *control) {
+	s.mutex.Lock()
+	if s.peers[id] == c {
+		delete(s.peers, id)
+		log.WithField("peer", id.Hex()).Debug("Unregistered peer")
+	}
+	s.mutex.Unlock()
+	c.conn.Close()
+}
+
+// dial notifies the peer that is named in `payload` of a new session and
+// forwards between `conn` and the connection with which the peer accepts.
+func (s *Server) dial(conn net.Conn, payload []byte) {
+	if len(payload) != common.AddressLength {
+		reject(conn, "malformed dial request")
+		return
+	}
+	id := common.BytesToAddress(payload)
+	var token [tokenLen]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		reject(conn, "internal error")
+		return
+	}
+	accepted := make(chan net.Conn, 1)
+
+	s.mutex.Lock()
+	c, ok := s.peers[id]
+	if ok {
+		s.pending[token] = accepted
+	}
+	s.mutex.Unlock()
+	if !ok {
+		reject(conn, "peer not registered")
+		return
+	}
+	defer s.abort(token, accepted)
+
+	if err := c.write(msgIncoming, token[:]); err != nil {
+		reject(conn, "peer unreachable")
+		return
+	}
+	select {
+	case peer := <-accepted:
+		if writeFrame(conn, msgOK, nil) != nil || writeFrame(peer, msgOK, nil) != nil {
+			conn.Close()
+			peer.Close()
+			return
+		}
+		splice(conn, peer)
+	case <-time.After(sessionTimeout):
+		reject(conn, "peer did not accept")
+	}
+}
+
+// accept hands `conn` to the session that is named by the token in
+// `payload`.
+func (s *Server) accept(conn net.Conn, payload []byte) {
+	var token [tokenLen]byte
+	if len(payload) != len(token) {
+		reject(conn, "malformed accept request")
+		return
+	}
+	copy(token[:], payload)
+
+	// The channel is buffered, so sending with the mutex held does not block
+	// and abort can not miss the connection.
+	s.mutex.Lock()
+	accepted, ok := s.pending[token]
+	delete(s.pending, token)
+	if ok {
+		accepted <- conn
+	}
+	s.mutex.Unlock()
+	if !ok {
+		reject(conn, "unknown session")
+	}
+}
+
+// abort removes the pending session `token`. The connection of a peer that
+// accepted the session but was not forwarded anymore is closed.
+func (s *Server) abort(token [tokenLen]byte, accepted chan net.Conn) {
+	s.mutex.Lock()
+	delete(s.pending, token)
+	s.mutex.Unlock()
+	select {
+	case peer := <-accepted:
+		peer.Close()
+	default:
+	}
+}
+
+// write writes a frame to the control connection.
+func (c *control) write(typ byte, payload []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(handshakeTimeout))
+	return writeFrame(c.conn, typ, payload)
+}
+
+// reject sends `reason` as error to `conn` and closes it.
+func reject(conn net.Conn, reason string) {
+	writeFrame(conn, msgError, []byte(reason))
+	conn.Close()
+}
+
+// splice forwards data between `a` and `b` until either side closes. Both
+// connections are closed afterwards.
+func splice(a, b net.Conn) {
+	a.SetDeadline(time.Time{})
+	b.SetDeadline(time.Time{})
+	done := make(chan struct{}, 2)
+	forward := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go forward(a, b)
+	go forward(b, a)
+	<-done
+	a.Close()
+	b.Close()
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package relay
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// startServer serves a new relay server on a local port and returns its
+// address.
+func startServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := NewServer()
+	go s.Serve(l)
+	return s, l.Addr().String()
+}
+
+// newKey returns a fresh key and its Perun ID.
+func newKey(t *testing.T) (*ecdsa.PrivateKey, common.Address) {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	return key, crypto.PubkeyToAddress(key.PublicKey)
+}
+
+// keySigner returns a Signer that signs with `key`.
+func keySigner(key *ecdsa.PrivateKey) Signer {
+	return func(hash []byte) ([]byte, error) {
+		return crypto.Sign(hash, key)
+	}
+}
+
+func TestRelayForwardsSessions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s, addr := startServer(t)
+	key, id := newKey(t)
+	l, err := Listen(ctx, addr, id, keySigner(key))
+	if err != nil {
+		t.Fatalf("registering: %v", err)
+	}
+	defer l.Close()
+	if n := s.NumPeers(); n != 1 {
+		t.Fatalf("%d peers registered, expected 1", n)
+	}
+
+	dialed, err := Dial(ctx, addr, id)
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	defer dialed.Close()
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatalf("accepting: %v", err)
+	}
+	defer accepted.Close()
+
+	for _, c := range [][2]net.Conn{{dialed, accepted}, {accepted, dialed}} {
+		if _, err := c[0].Write([]byte("ping")); err != nil {
+			t.Fatalf("writing: %v", err)
+		}
+		buf := make([]byte, 4)
+		c[1].SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.ReadFull(c[1], buf); err != nil || string(buf) != "ping" {
+			t.Fatalf("read %q (%v), expected ping", buf, err)
+		}
+	}
+}
+
+func TestRelayRejectsUnregisteredPeers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, addr := startServer(t)
+	_, id := newKey(t)
+
+	if _, err := Dial(ctx, addr, id); err == nil || !strings.Contains(err.Error(), "peer not registered") {
+		t.Fatalf("dialing unregistered peer returned %v", err)
+	}
+}
+
+func TestRelayRejectsForeignRegistrations(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s, addr := startServer(t)
+	_, id := newKey(t)
+	other, _ := newKey(t)
+
+	if _, err := Listen(ctx, addr, id, keySigner(other)); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Fatalf("registering with foreign signature returned %v", err)
+	}
+	if n := s.NumPeers(); n != 0 {
+		t.Fatalf("%d peers registered, expected 0", n)
+	}
+}
+
+// TestServerClosesLateAccepts checks that a session that is accepted while
+// its dial times out is closed.
+func TestServerClosesLateAccepts(t *testing.T) {
+	s := NewServer()
+	var token [tokenLen]byte
+	accepted := make(chan net.Conn, 1)
+	s.pending[token] = accepted
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	s.accept(conn, token[:])
+	s.abort(token, accepted)
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := peer.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("reading from late session returned %v, expected EOF", err)
+	}
+	if len(s.pending) != 0 {
+		t.Fatal("aborted session still pending")
+	}
+}
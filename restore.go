@@ -0,0 +1,226 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire"
+)
+
+// defaultRestoreWorkers is the number of peers that Restore connects to
+// concurrently if Config.RestoreWorkers is not set.
+const defaultRestoreWorkers = 4
+
+type (
+	// RestoreProgress is notified about the progress of Client.Restore.
+	// Its functions may be called concurrently.
+	RestoreProgress interface {
+		// OnChannelRestored is called for every restored channel with the
+		// number of channels that were restored so far and the total number
+		// of persisted channels.
+		OnChannelRestored(ch *PaymentChannel, restored, total int)
+		// OnPeerUnreachable is called for every peer that could not be
+		// connected.
+		OnPeerUnreachable(peer *Address, reason string)
+	}
+
+	// RestoreResult is the outcome of restoring a single channel.
+	RestoreResult struct {
+		id   channel.ID
+		peer wire.Address
+		ch   *PaymentChannel // nil if not restored
+		err  error           // nil if restored and the peer was reachable
+	}
+
+	// RestoreResults is a slice of RestoreResult's.
+	RestoreResults struct {
+		values []*RestoreResult
+	}
+)
+
+// Restore restores all channels from persistence and returns the outcome for
+// every persisted channel. Newly restored channels can also be acquired
+// through the OnNewChannel callback.
+// The peers of the channels are connected concurrently, at most
+// Config.RestoreWorkers at a time. An unreachable peer does not prevent the
+// channels with other peers from being restored; it is reported to
+// `progress` and in the results instead. `progress` may be nil.
+// An error is only returned if the persisted channels could not be read.
+// ref https://pkg.go.dev/perun.network/go-perun/client?tab=doc#Client.Restore
+func (c *Client) Restore(ctx *Context, progress RestoreProgress) (*RestoreResults, error) {
+	if c.persister == nil {
+		return nil, newError(ErrorInvalidArgument, "", errors.New("persistence not enabled"))
+	}
+	results, peers, err := c.persistedChannels(ctx.ctx)
+	if err != nil {
+		return nil, newError(ErrorPersistence, "", errors.WithMessage(err, "reading persisted channels"))
+	}
+	peerErrs := c.connectPeers(ctx.ctx, peers, progress)
+
+	expected := make(map[channel.ID]bool, len(results))
+	for _, r := range results {
+		expected[r.id] = true
+	}
+	var mutex sync.Mutex
+	restored := 0
+	c.setRestoring(func(pch *PaymentChannel) {
+		if !expected[pch.ch.ID()] {
+			return
+		}
+		mutex.Lock()
+		restored++
+		n := restored
+		mutex.Unlock()
+		if progress != nil {
+			progress.OnChannelRestored(pch, n, len(results))
+		}
+	})
+	restoreErr := c.client.Restore(ctx.ctx)
+	c.setRestoring(nil)
+	if restoreErr != nil {
+		log.WithError(restoreErr).Warn("Restoring channels")
+	}
+
+	for _, r := range results {
+		r.ch = c.trackedChannel(r.id)
+		r.err = peerErrs[wallet.Key(r.peer)]
+		switch {
+		case r.ch != nil || r.err != nil:
+		case restoreErr != nil:
+			r.err = restoreErr
+		default:
+			r.err = errors.New("channel was not restored")
+		}
+	}
+	return &RestoreResults{values: results}, nil
+}
+
+// persistedChannels returns a result for every persisted channel and the
+// peers of the channels.
+func (c *Client) persistedChannels(ctx context.Context) ([]*RestoreResult, []wire.Address, error) {
+	all, err := c.persister.ActivePeers(ctx)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "reading peers")
+	}
+	var (
+		results []*RestoreResult
+		peers   []wire.Address
+		seen    = make(map[channel.ID]bool)
+	)
+	for _, peer := range all {
+		if peer.Equal(c.onChain.Address()) {
+			continue
+		}
+		peers = append(peers, peer)
+		it, err := c.persister.RestorePeer(peer)
+		if err != nil {
+			return nil, nil, errors.WithMessagef(err, "reading channels of peer %v", peer)
+		}
+		for it.Next(ctx) {
+			if id := it.Channel().ID(); !seen[id] {
+				seen[id] = true
+				results = append(results, &RestoreResult{id: id, peer: peer})
+			}
+		}
+		err = it.Err()
+		it.Close()
+		if err != nil {
+			return nil, nil, errors.WithMessagef(err, "reading channels of peer %v", peer)
+		}
+	}
+	return results, peers, nil
+}
+
+// connectPeers connects to `peers` with a bounded number of workers and
+// returns the errors of the peers that could not be connected.
+func (c *Client) connectPeers(ctx context.Context, peers []wire.Address, progress RestoreProgress) map[wallet.AddrKey]error {
+	workers := c.cfg.RestoreWorkers
+	if workers <= 0 {
+		workers = defaultRestoreWorkers
+	}
+	var (
+		mutex sync.Mutex
+		wg    sync.WaitGroup
+		errs  = make(map[wallet.AddrKey]error)
+		jobs  = make(chan wire.Address)
+	)
+	for i := 0; i < workers && i < len(peers); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for peer := range jobs {
+				dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+				err := c.connect(dialCtx, peer)
+				cancel()
+				if err == nil {
+					continue
+				}
+				err = errors.WithMessage(err, "connecting to peer")
+				mutex.Lock()
+				errs[wallet.Key(peer)] = err
+				mutex.Unlock()
+				if progress != nil {
+					progress.OnPeerUnreachable(toAddress(peer), err.Error())
+				}
+			}
+		}()
+	}
+	for _, peer := range peers {
+		jobs <- peer
+	}
+	close(jobs)
+	wg.Wait()
+	return errs
+}
+
+// GetID returns the ID of the channel.
+func (r *RestoreResult) GetID() []byte {
+	return r.id[:]
+}
+
+// GetPeer returns the Perun ID of the peer of the channel.
+func (r *RestoreResult) GetPeer() *Address {
+	return toAddress(r.peer)
+}
+
+// IsRestored returns whether the channel was restored.
+func (r *RestoreResult) IsRestored() bool {
+	return r.ch != nil
+}
+
+// GetChannel returns the restored channel or nil if it was not restored.
+func (r *RestoreResult) GetChannel() *PaymentChannel {
+	return r.ch
+}
+
+// GetError describes why the channel was not restored or its peer was not
+// reachable. It is empty otherwise.
+func (r *RestoreResult) GetError() string {
+	if r.err == nil {
+		return ""
+	}
+	return r.err.Error()
+}
+
+// Length returns the length of the RestoreResults slice.
+func (rs *RestoreResults) Length() int {
+	return len(rs.values)
+}
+
+// Get returns the element at the given index.
+func (rs *RestoreResults) Get(index int) (*RestoreResult, error) {
+	if index < 0 || index >= len(rs.values) {
+		return nil, errors.New("get: index out of range")
+	}
+	return rs.values[index], nil
+}
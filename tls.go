@@ -0,0 +1,264 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/wire"
+	wirenet "perun.network/go-perun/wire/net"
+)
+
+// tlsBindingPrefix is prepended to the public key of a pinned certificate
+// before it is signed with the Perun ID.
+const tlsBindingPrefix = "perun-eth-mobile TLS binding"
+
+// oidPerunIDBinding identifies the certificate extension that contains the
+// signature of the Perun ID over the certificate's public key.
+var oidPerunIDBinding = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 59522, 1, 1}
+
+// tlsSetup holds the TLS configuration of a transport.
+type tlsSetup struct {
+	// server is used for serving incoming connections.
+	server *tls.Config
+	// client returns the configuration for dialing the peer `peer`.
+	client func(peer wire.Address) *tls.Config
+	// pinned is set if certificates are bound to Perun IDs. Connections must
+	// then be wrapped by newPinnedConn.
+	pinned bool
+}
+
+// pinnedConn is a connection secured by a pinned certificate. It only
+// accepts envelopes that were sent by the Perun ID that the certificate of
+// the peer is bound to.
+type pinnedConn struct {
+	wirenet.Conn
+	tls *tls.Conn // nil if the ID is known from the start
+
+	mutex sync.Mutex
+	id    *common.Address // nil until the handshake completed
+}
+
+// newTLSSetup creates the TLS configuration of `cfg`.
+// If a certificate is configured, it is served and peers are verified against
+// the root CAs. Otherwise, a self-signed certificate is generated whose key is
+// signed by the Perun ID `id`, and peers are pinned to their Perun IDs.
+func newTLSSetup(cfg *Config, w *Wallet, id accounts.Account) (*tlsSetup, error) {
+	if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" {
+		return newCATLSSetup(cfg)
+	}
+	cert, err := newPinnedCertificate(w, id)
+	if err != nil {
+		return nil, errors.WithMessage(err, "creating pinned certificate")
+	}
+	server := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		ClientAuth:   tls.RequireAnyClientCert,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			_, err := pinnedPerunID(rawCerts)
+			return err
+		},
+	}
+	client := func(peer wire.Address) *tls.Config {
+		expected := common.Address(*peer.(*ethwallet.Address))
+		return &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+			// The certificate chain is replaced by the Perun ID binding.
+			InsecureSkipVerify: true,
+			VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				id, err := pinnedPerunID(rawCerts)
+				if err != nil {
+					return err
+				}
+				if id != expected {
+					return errors.Errorf("certificate bound to %s, expected %s", id.Hex(), expected.Hex())
+				}
+				return nil
+			},
+		}
+	}
+	return &tlsSetup{server: server, client: client, pinned: true}, nil
+}
+
+// newCATLSSetup serves the certificate of `cfg` and verifies peers against
+// the system's root CAs or the root CA file of `cfg`.
+func newCATLSSetup(cfg *Config) (*tlsSetup, error) {
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return nil, errors.New("TLS certificate and key file must both be set")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, errors.WithMessage(err, "loading TLS certificate")
+	}
+	var roots *x509.CertPool
+	if cfg.TLSRootCAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.TLSRootCAFile)
+		if err != nil {
+			return nil, errors.WithMessage(err, "reading TLS root CA file")
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in TLS root CA file")
+		}
+	}
+
+	server := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	client := func(wire.Address) *tls.Config {
+		return &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	}
+	return &tlsSetup{server: server, client: client}, nil
+}
+
+// newPinnedCertificate generates a self-signed certificate for a fresh key.
+// The public key is signed by the Perun ID `id` and the signature is embedded
+// as certificate extension.
+func newPinnedCertificate(w *Wallet, id accounts.Account) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, errors.WithMessage(err, "generating key")
+	}
+	spki, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return tls.Certificate{}, errors.WithMessage(err, "encoding public key")
+	}
+	sig, err := w.w.Ks.SignHashWithPassphrase(id, w.password, tlsBindingHash(spki))
+	if err != nil {
+		return tls.Certificate{}, errors.WithMessage(err, "signing public key")
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, errors.WithMessage(err, "generating serial number")
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:    serial,
+		Subject:         pkix.Name{CommonName: id.Address.Hex()},
+		NotBefore:       now.Add(-time.Hour),
+		NotAfter:        now.AddDate(10, 0, 0),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		ExtraExtensions: []pkix.Extension{{Id: oidPerunIDBinding, Value: sig}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, errors.WithMessage(err, "creating certificate")
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// pinnedPerunID returns the Perun ID that the leaf of `rawCerts` is bound to.
+func pinnedPerunID(rawCerts [][]byte) (common.Address, error) {
+	if len(rawCerts) == 0 {
+		return common.Address{}, errors.New("no certificate presented")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return common.Address{}, errors.WithMessage(err, "parsing certificate")
+	}
+	if now := time.Now(); now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return common.Address{}, errors.New("certificate expired or not yet valid")
+	}
+	if err := cert.CheckSignatureFrom(cert); err != nil {
+		return common.Address{}, errors.WithMessage(err, "checking self-signature")
+	}
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidPerunIDBinding) {
+			continue
+		}
+		pub, err := crypto.SigToPub(tlsBindingHash(cert.RawSubjectPublicKeyInfo), ext.Value)
+		if err != nil {
+			return common.Address{}, errors.WithMessage(err, "recovering Perun ID")
+		}
+		id := crypto.PubkeyToAddress(*pub)
+		if id != common.HexToAddress(cert.Subject.CommonName) {
+			return common.Address{}, errors.New("certificate subject does not match Perun ID")
+		}
+		return id, nil
+	}
+	return common.Address{}, errors.New("certificate not bound to a Perun ID")
+}
+
+// newPinnedConn wraps `conn` so that it only accepts envelopes sent by the
+// Perun ID `id`. If `id` is nil, it is taken from the certificate that the
+// peer presents during the handshake of `tlsConn`.
+func newPinnedConn(conn wirenet.Conn, tlsConn *tls.Conn, id *common.Address) *pinnedConn {
+	return &pinnedConn{Conn: conn, tls: tlsConn, id: id}
+}
+
+// Recv implements wirenet.Conn.Recv. The connection is closed if the sender
+// of an envelope is not the pinned Perun ID.
+func (c *pinnedConn) Recv() (*wire.Envelope, error) {
+	e, err := c.Conn.Recv()
+	if err != nil {
+		return nil, err
+	}
+	id, err := c.PeerID()
+	if err == nil {
+		sender, ok := e.Sender.(*ethwallet.Address)
+		if !ok || common.Address(*sender) != *id {
+			err = errors.WithMessagef(errPeerImpersonated, "envelope from %v over connection pinned to %s", e.Sender, id.Hex())
+		}
+	}
+	if err != nil {
+		c.Conn.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// PeerID returns the Perun ID that the connection is pinned to. It fails if
+// the handshake did not complete yet.
+func (c *pinnedConn) PeerID() (*common.Address, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.id != nil {
+		return c.id, nil
+	}
+	state := c.tls.ConnectionState()
+	if !state.HandshakeComplete {
+		return nil, errors.New("TLS handshake not completed")
+	}
+	id, err := pinnedPerunID(rawCertificates(state.PeerCertificates))
+	if err != nil {
+		return nil, err
+	}
+	c.id = &id
+	return c.id, nil
+}
+
+// rawCertificates returns the DER encodings of `certs`.
+func rawCertificates(certs []*x509.Certificate) [][]byte {
+	raw := make([][]byte, len(certs))
+	for i, cert := range certs {
+		raw[i] = cert.Raw
+	}
+	return raw
+}
+
+// tlsBindingHash returns the hash that is signed to bind the public key
+// `spki` to a Perun ID.
+func tlsBindingHash(spki []byte) []byte {
+	return crypto.Keccak256([]byte(tlsBindingPrefix), spki)
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/backend/ethereum/wallet/keystore"
+)
+
+// erc20ABI is the part of the ERC-20 ABI that is used for transfers and
+// balances.
+const erc20ABI = `[{"constant":false,"inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint256"}],"name":"transfer","outputs":[{"name":"","type":"bool"}],"stateMutability":"nonpayable","type":"function"},{"constant":true,"inputs":[{"name":"owner","type":"address"}],"name":"balanceOf","outputs":[{"name":"","type":"uint256"}],"stateMutability":"view","type":"function"}]`
+
+// erc20 is the parsed erc20ABI.
+var erc20 = mustParseABI(erc20ABI)
+
+// Transfer sends `amount` Wei from the on-chain account of the Client to
+// `to`. It blocks until the transaction is final and returns its hash.
+// The nonce is shared with the channel transactions, so transfers can be
+// sent concurrently with deposits.
+func (c *Client) Transfer(ctx *Context, to *Address, amount *BigInt) (string, error) {
+	if err := checkTransfer(to, amount); err != nil {
+		return "", err
+	}
+	// The recipient can be a contract that needs more than transferGas.
+	toAddr := common.Address(to.addr)
+	gas, err := c.estimateGas(ctx.ctx, toAddr, amount.i, nil)
+	if err != nil {
+		return "", toError(err)
+	}
+	contract := bind.NewBoundContract(toAddr, abi.ABI{}, c.ethClient, c.txs, nil)
+	hash, err := c.transact(ctx.ctx, gas, amount.i, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return contract.Transfer(opts)
+	})
+	return hash, toError(err)
+}
+
+// TransferToken sends `amount` of the ERC-20 `token` from the on-chain
+// account of the Client to `to` like Transfer. The token must be listed in
+// Config.Tokens.
+func (c *Client) TransferToken(ctx *Context, token, to *Address, amount *BigInt) (string, error) {
+	if err := checkTransfer(to, amount); err != nil {
+		return "", err
+	}
+	contract, err := c.token(token)
+	if err != nil {
+		return "", err
+	}
+	input, err := erc20.Pack("transfer", common.Address(to.addr), amount.i)
+	if err != nil {
+		return "", toError(errors.Wrap(err, "packing transfer"))
+	}
+	gas, err := c.estimateGas(ctx.ctx, common.Address(token.addr), nil, input)
+	if err != nil {
+		return "", toError(err)
+	}
+	hash, err := c.transact(ctx.ctx, gas, nil, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return contract.RawTransact(opts, input)
+	})
+	return hash, toError(err)
+}
+
+// estimateGas estimates the gas of a transaction from the on-chain account
+// to `to`. It is called before the nonce is reserved, so that a failing
+// estimation does not leave a gap.
+func (c *Client) estimateGas(ctx context.Context, to common.Address, value *big.Int, input []byte) (uint64, error) {
+	gas, err := c.txs.EstimateGas(ctx, ethereum.CallMsg{
+		From:  common.Address(*c.onChain.Address().(*ethwallet.Address)),
+		To:    &to,
+		Value: value,
+		Data:  input,
+	})
+	return gas, errors.WithMessage(err, "estimating gas")
+}
+
+// checkTransfer checks the arguments of a transfer.
+func checkTransfer(to *Address, amount *BigInt) error {
+	switch {
+	case common.Address(to.addr) == common.Address{}:
+		return newError(ErrorInvalidArgument, "", errors.New("recipient must not be the zero address"))
+	case amount.i.Sign() < 1:
+		return newError(ErrorInvalidArgument, "", errors.New("only positive amounts can be transferred"))
+	}
+	return nil
+}
+
+// token returns the bound ERC-20 contract of `token` if it is listed in
+// Config.Tokens.
+func (c *Client) token(token *Address) (*bind.BoundContract, error) {
+	addr := common.Address(token.addr)
+	for _, t := range c.tokens() {
+		if t == addr {
+			return c.erc20Contract(addr), nil
+		}
+	}
+	return nil, newError(ErrorInvalidArgument, "", errors.Errorf("token %s not configured", addr.Hex()))
+}
+
+// tokens returns the addresses of Config.Tokens.
+func (c *Client) tokens() []common.Address {
+	if c.cfg.Tokens == nil {
+		return nil
+	}
+	tokens := make([]common.Address, len(c.cfg.Tokens.values))
+	for i, t := range c.cfg.Tokens.values {
+		tokens[i] = common.Address(t)
+	}
+	return tokens
+}
+
+// erc20Contract returns the bound ERC-20 contract at `addr`.
+func (c *Client) erc20Contract(addr common.Address) *bind.BoundContract {
+	return bind.NewBoundContract(addr, erc20, c.ethClient, c.txs, nil)
+}
+
+// mustParseABI parses the ABI `def` and panics on failure.
+func mustParseABI(def string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(def))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+// transact sends the transaction that `send` creates with the transactor of
+// the channel contracts and waits until it is final. `gasLimit` and `value`
+// are set in the transaction, the gas limit must not be zero since the
+// nonce is reserved before `send` is called. If the nonce was already used
+// by another wallet, the nonceManager sends the transaction again with a new
+// nonce.
+func (c *Client) transact(ctx context.Context, gasLimit uint64, value *big.Int, send func(*bind.TransactOpts) (*types.Transaction, error)) (string, error) {
+	acc := c.onChain.(*keystore.Account).Account
+	opts, err := c.cb.NewTransactor(ctx, gasLimit, acc)
+	if err != nil {
+		return "", errors.WithMessage(err, "creating transactor")
+	}
+	opts.Context, opts.Value = ctx, value
+	tx, err := send(opts)
+	if err != nil {
+		return "", errors.WithMessage(err, "sending transaction")
+	}
+	receipt, err := c.cb.ConfirmTransaction(ctx, tx, acc)
+	if err != nil {
+		return tx.Hash().Hex(), errors.WithMessage(err, "confirming transaction")
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return tx.Hash().Hex(), errors.New("transaction reverted")
+	}
+	return tx.Hash().Hex(), nil
+}
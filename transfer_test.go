@@ -0,0 +1,106 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	ethkeystore "github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	_ "perun.network/go-perun/backend/ethereum" // backend init
+	"perun.network/go-perun/backend/ethereum/bindings/peruntoken"
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+	ethchanneltest "perun.network/go-perun/backend/ethereum/channel/test"
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/backend/ethereum/wallet/keystore"
+)
+
+// storeValueCode deploys a contract whose fallback function stores the
+// received value in slot 0.
+const storeValueCode = "0x6434600055006000526005601bf3"
+
+func TestTransfer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	sb := ethchanneltest.NewSimulatedBackend()
+	sb.StartMining(10 * time.Millisecond)
+	defer sb.StopMining()
+	closed := make(chan struct{})
+	defer close(closed)
+
+	w, err := keystore.NewWallet(ethkeystore.NewKeyStore(t.TempDir(), ethkeystore.LightScryptN, ethkeystore.LightScryptP), "")
+	if err != nil {
+		t.Fatalf("creating wallet: %v", err)
+	}
+	acc := w.NewAccount()
+	sb.FundAddress(ctx, acc.Account.Address)
+	tr := keystore.NewTransactor(*w, types.NewEIP155Signer(big.NewInt(1337)))
+	txs := newTxTracker(sb, 1, closed)
+	c := &Client{cfg: new(Config), cb: ethchannel.NewContractBackend(txs, tr, 1), txs: txs, onChain: acc}
+	pctx := &Context{ctx: ctx}
+	to := common.Address{1}
+
+	// The recipient is an externally owned account without code.
+	if _, err := c.Transfer(pctx, &Address{ethwallet.Address(to)}, &BigInt{big.NewInt(1000)}); err != nil {
+		t.Fatalf("transferring ETH: %v", err)
+	}
+	if bal, err := sb.BalanceAt(ctx, to, nil); err != nil || bal.Cmp(big.NewInt(1000)) != 0 {
+		t.Fatalf("recipient has %v Wei (%v), expected 1000", bal, err)
+	}
+
+	opts, err := tr.NewTransactor(acc.Account)
+	if err != nil {
+		t.Fatalf("creating transactor: %v", err)
+	}
+	// The recipient is a contract whose fallback stores the received value,
+	// which needs more gas than a plain transfer.
+	walletAddr, deployTx, _, err := bind.DeployContract(opts, abi.ABI{}, common.FromHex(storeValueCode), sb)
+	if err != nil {
+		t.Fatalf("deploying recipient contract: %v", err)
+	}
+	if _, err := bind.WaitDeployed(ctx, sb, deployTx); err != nil {
+		t.Fatalf("waiting for recipient deployment: %v", err)
+	}
+	if _, err := c.Transfer(pctx, &Address{ethwallet.Address(walletAddr)}, &BigInt{big.NewInt(1000)}); err != nil {
+		t.Fatalf("transferring ETH to contract: %v", err)
+	}
+	if stored, err := sb.StorageAt(ctx, walletAddr, common.Hash{}, nil); err != nil || new(big.Int).SetBytes(stored).Cmp(big.NewInt(1000)) != 0 {
+		t.Fatalf("recipient contract stored %x (%v), expected 1000", stored, err)
+	}
+
+	tokenAddr, deployTx, token, err := peruntoken.DeployPerunToken(opts, sb, []common.Address{acc.Account.Address}, big.NewInt(1e18))
+	if err != nil {
+		t.Fatalf("deploying token: %v", err)
+	}
+	if _, err := bind.WaitDeployed(ctx, sb, deployTx); err != nil {
+		t.Fatalf("waiting for token deployment: %v", err)
+	}
+	c.cfg.Tokens = &Addresses{values: []ethwallet.Address{ethwallet.Address(tokenAddr)}}
+	tokenArg := &Address{ethwallet.Address(tokenAddr)}
+
+	if _, err := c.TransferToken(pctx, tokenArg, &Address{ethwallet.Address(to)}, &BigInt{big.NewInt(500)}); err != nil {
+		t.Fatalf("transferring tokens: %v", err)
+	}
+	if bal, err := token.BalanceOf(&bind.CallOpts{Context: ctx}, to); err != nil || bal.Cmp(big.NewInt(500)) != 0 {
+		t.Fatalf("recipient has %v tokens (%v), expected 500", bal, err)
+	}
+
+	// A transfer that fails the gas estimation must not reserve a nonce,
+	// otherwise the following transfer would never be mined.
+	if _, err := c.TransferToken(pctx, tokenArg, &Address{ethwallet.Address(to)}, &BigInt{big.NewInt(2e18)}); err == nil {
+		t.Fatal("transferring more tokens than owned succeeded")
+	}
+	if _, err := c.Transfer(pctx, &Address{ethwallet.Address(to)}, &BigInt{big.NewInt(1000)}); err != nil {
+		t.Fatalf("transferring ETH after failed estimation: %v", err)
+	}
+}
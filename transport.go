@@ -0,0 +1,109 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire"
+	wirenet "perun.network/go-perun/wire/net"
+	"perun.network/go-perun/wire/net/simple"
+)
+
+// Wire transports that can be selected with Config.Transport.
+const (
+	// TransportTCP connects peers over plain TCP. This is the default.
+	TransportTCP = "tcp"
+	// TransportTLS connects peers over TCP secured by TLS.
+	TransportTLS = "tls"
+	// TransportWebSocket connects peers over WebSockets.
+	TransportWebSocket = "ws"
+	// TransportWebSocketTLS connects peers over WebSockets secured by TLS.
+	TransportWebSocketTLS = "wss"
+	// TransportRelay connects peers through the relay server at
+	// Config.RelayAddress. Peers are reached by their Perun ID, so they do
+	// not need to be reachable themselves. Sessions are secured by TLS with
+	// certificates pinned to Perun IDs.
+	TransportRelay = "relay"
+)
+
+// dialTimeout is the default timeout for dialing a peer.
+const dialTimeout = 15 * time.Second
+
+type (
+	// peerDialer is a wirenet.Dialer that the network addresses of peers can
+	// be registered with.
+	peerDialer interface {
+		wirenet.Dialer
+		Register(addr wire.Address, address string)
+	}
+
+	// peerRegistry maps Perun IDs of peers to their network addresses.
+	peerRegistry struct {
+		mutex sync.RWMutex
+		peers map[wallet.AddrKey]string
+	}
+)
+
+// newTransport creates the listener and dialer of the transport that is
+// selected in `cfg`. The Perun ID `id` is used to authenticate TLS
+// connections.
+func newTransport(cfg *Config, w *Wallet, id accounts.Account) (wirenet.Listener, peerDialer, error) {
+	endpoint := fmt.Sprintf("%s:%d", cfg.IP, cfg.Port)
+	var setup *tlsSetup
+	switch cfg.Transport {
+	case "", TransportTCP, TransportWebSocket:
+	case TransportRelay:
+		return newRelayTransport(cfg, w, id)
+	case TransportTLS, TransportWebSocketTLS:
+		var err error
+		if setup, err = newTLSSetup(cfg, w, id); err != nil {
+			return nil, nil, errors.WithMessage(err, "setting up TLS")
+		}
+	default:
+		return nil, nil, errors.Errorf("unknown transport: %s", cfg.Transport)
+	}
+
+	switch cfg.Transport {
+	case TransportTLS:
+		return newTLSTransport(endpoint, setup)
+	case TransportWebSocket, TransportWebSocketTLS:
+		return newWSTransport(endpoint, setup)
+	default:
+		listener, err := simple.NewTCPListener(endpoint)
+		if err != nil {
+			return nil, nil, errors.WithMessagef(err, "listening on %s", endpoint)
+		}
+		return listener, simple.NewTCPDialer(dialTimeout), nil
+	}
+}
+
+// Register registers the network address of the peer with Perun ID `addr`.
+func (r *peerRegistry) Register(addr wire.Address, address string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.peers == nil {
+		r.peers = make(map[wallet.AddrKey]string)
+	}
+	r.peers[wallet.Key(addr)] = address
+}
+
+// get returns the network address of the peer with Perun ID `addr`.
+func (r *peerRegistry) get(addr wire.Address) (string, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	address, ok := r.peers[wallet.Key(addr)]
+	if !ok {
+		return "", errors.Errorf("peer not found: %v", addr)
+	}
+	return address, nil
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/wire"
+	wirenet "perun.network/go-perun/wire/net"
+
+	"github.com/perun-network/perun-eth-mobile/relay"
+)
+
+type (
+	// relayListener is a wirenet.Listener that accepts sessions which are
+	// relayed to our Perun ID. Sessions are secured by TLS with pinned
+	// certificates, since the relay does not authenticate dialers.
+	relayListener struct {
+		l   *relay.Listener
+		tls *tlsSetup
+	}
+
+	// relayDialer is a peerDialer that reaches peers by their Perun ID through
+	// the relay. Registered network addresses are ignored. Sessions are
+	// secured by TLS with pinned certificates, so that neither the relay nor
+	// anyone else can answer in place of the peer.
+	relayDialer struct {
+		relay string
+		tls   *tlsSetup
+	}
+)
+
+// newRelayTransport registers the Perun ID `id` at the relay of `cfg` and
+// returns a listener for relayed sessions and a dialer that dials through the
+// relay. Relayed sessions use TLS with certificates pinned to Perun IDs.
+func newRelayTransport(cfg *Config, w *Wallet, id accounts.Account) (wirenet.Listener, peerDialer, error) {
+	if cfg.RelayAddress == "" {
+		return nil, nil, errors.New("relay address must be set")
+	}
+	// CA certificates name hosts, which relayed peers do not have.
+	if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" {
+		return nil, nil, errors.New("TLS certificate files are not supported by the relay transport")
+	}
+	setup, err := newTLSSetup(cfg, w, id)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "setting up TLS")
+	}
+	sign := func(hash []byte) ([]byte, error) {
+		return w.w.Ks.SignHashWithPassphrase(id, w.password, hash)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	l, err := relay.Listen(ctx, cfg.RelayAddress, id.Address, sign)
+	if err != nil {
+		return nil, nil, errors.WithMessagef(err, "registering at relay %s", cfg.RelayAddress)
+	}
+	return &relayListener{l: l, tls: setup}, &relayDialer{relay: cfg.RelayAddress, tls: setup}, nil
+}
+
+// Accept implements wirenet.Listener.Accept. The TLS handshake is done on
+// first use of the session, which then only accepts envelopes from the
+// Perun ID of the client certificate.
+func (l *relayListener) Accept() (wirenet.Conn, error) {
+	conn, err := l.l.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Server(conn, l.tls.server)
+	return newPinnedConn(wirenet.NewIoConn(tlsConn), tlsConn, nil), nil
+}
+
+// Close implements wirenet.Listener.Close.
+func (l *relayListener) Close() error {
+	return l.l.Close()
+}
+
+// Dial implements wirenet.Dialer.Dial. The peer must present a certificate that is
+// bound to its Perun ID `addr`.
+func (d *relayDialer) Dial(ctx context.Context, addr wire.Address) (wirenet.Conn, error) {
+	id := common.Address(*addr.(*ethwallet.Address))
+	conn, err := relay.Dial(ctx, d.relay, id)
+	if err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, d.tls.client(addr))
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "TLS handshake with %s", id.Hex())
+	}
+	return newPinnedConn(wirenet.NewIoConn(tlsConn), nil, &id), nil
+}
+
+// Register is a no-op, since peers are reached by their Perun ID.
+func (d *relayDialer) Register(wire.Address, string) {}
+
+// Close implements wirenet.Dialer.Close. Relay dialers hold no resources.
+func (d *relayDialer) Close() error {
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/wire"
+	wirenet "perun.network/go-perun/wire/net"
+)
+
+type (
+	// tlsListener is a wirenet.Listener that accepts TLS connections.
+	tlsListener struct {
+		net.Listener
+		pinned bool
+	}
+
+	// tlsDialer is a peerDialer that dials peers over TLS.
+	tlsDialer struct {
+		peerRegistry
+		dialer net.Dialer
+		tls    *tlsSetup
+	}
+)
+
+// newTLSTransport creates a TLS listener on `endpoint` and a matching dialer.
+func newTLSTransport(endpoint string, setup *tlsSetup) (wirenet.Listener, peerDialer, error) {
+	ln, err := tls.Listen("tcp", endpoint, setup.server)
+	if err != nil {
+		return nil, nil, errors.WithMessagef(err, "listening on %s", endpoint)
+	}
+	dialer := &tlsDialer{
+		dialer: net.Dialer{Timeout: dialTimeout},
+		tls:    setup,
+	}
+	return &tlsListener{Listener: ln, pinned: setup.pinned}, dialer, nil
+}
+
+// Accept implements wirenet.Listener.Accept. The TLS handshake is done on
+// first use of the connection. With pinned certificates, the connection only
+// accepts envelopes from the Perun ID of the client certificate.
+func (l *tlsListener) Accept() (wirenet.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, errors.Wrap(err, "accepting connection")
+	}
+	if l.pinned {
+		return newPinnedConn(wirenet.NewIoConn(conn), conn.(*tls.Conn), nil), nil
+	}
+	return wirenet.NewIoConn(conn), nil
+}
+
+// Dial implements wirenet.Dialer.Dial. The peer must present a certificate
+// that is valid for its Perun ID `addr`.
+func (d *tlsDialer) Dial(ctx context.Context, addr wire.Address) (wirenet.Conn, error) {
+	host, err := d.get(addr)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &tls.Dialer{NetDialer: &d.dialer, Config: d.tls.client(addr)}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "dialing %s", host)
+	}
+	if d.tls.pinned {
+		id := common.Address(*addr.(*ethwallet.Address))
+		return newPinnedConn(wirenet.NewIoConn(conn), nil, &id), nil
+	}
+	return wirenet.NewIoConn(conn), nil
+}
+
+// Close implements wirenet.Dialer.Close. TLS dialers hold no resources.
+func (d *tlsDialer) Close() error {
+	return nil
+}
@@ -0,0 +1,225 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/wire"
+	wirenet "perun.network/go-perun/wire/net"
+)
+
+// wsPath is the HTTP path on which WebSocket connections are accepted.
+const wsPath = "/perun"
+
+type (
+	// wsListener is a wirenet.Listener that accepts WebSocket connections.
+	wsListener struct {
+		server    *http.Server
+		upgrader  websocket.Upgrader
+		conns     chan wirenet.Conn
+		closed    chan struct{}
+		closeOnce sync.Once
+		pinned    bool // whether client certificates are pinned to Perun IDs
+	}
+
+	// wsDialer is a peerDialer that dials peers over WebSockets.
+	wsDialer struct {
+		peerRegistry
+		scheme string
+		dialer websocket.Dialer
+		tls    *tlsSetup // nil for plain WebSockets.
+	}
+
+	// wsConn adapts a WebSocket connection to an io.ReadWriteCloser. Every
+	// write is sent as one binary message, reads continue across message
+	// boundaries.
+	wsConn struct {
+		ws *websocket.Conn
+		r  io.Reader // Reader of the current message.
+	}
+)
+
+// newWSTransport creates a WebSocket listener on `endpoint` and a matching
+// dialer. If `setup` is not nil, connections are secured by TLS (wss).
+func newWSTransport(endpoint string, setup *tlsSetup) (wirenet.Listener, peerDialer, error) {
+	scheme := "ws"
+	var serverTLS *tls.Config
+	if setup != nil {
+		scheme = "wss"
+		serverTLS = setup.server
+	}
+
+	listener, err := newWSListener(endpoint, serverTLS)
+	if err != nil {
+		return nil, nil, err
+	}
+	listener.pinned = setup != nil && setup.pinned
+	dialer := &wsDialer{
+		scheme: scheme,
+		dialer: websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: dialTimeout,
+		},
+		tls: setup,
+	}
+	return listener, dialer, nil
+}
+
+// newWSListener listens for WebSocket connections on `endpoint`. If `tlsCfg`
+// is not nil, connections are served over TLS.
+func newWSListener(endpoint string, tlsCfg *tls.Config) (*wsListener, error) {
+	ln, err := net.Listen("tcp", endpoint)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "listening on %s", endpoint)
+	}
+	if tlsCfg != nil {
+		ln = tls.NewListener(ln, tlsCfg)
+	}
+
+	l := &wsListener{
+		upgrader: websocket.Upgrader{HandshakeTimeout: dialTimeout},
+		conns:    make(chan wirenet.Conn),
+		closed:   make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(wsPath, l.serveWS)
+	l.server = &http.Server{Handler: mux}
+	go func() {
+		if err := l.server.Serve(ln); err != http.ErrServerClosed {
+			log.WithError(err).Error("WebSocket listener stopped")
+			l.Close()
+		}
+	}()
+	return l, nil
+}
+
+// serveWS upgrades an incoming HTTP request and hands the connection to
+// Accept.
+func (l *wsListener) serveWS(w http.ResponseWriter, r *http.Request) {
+	ws, err := l.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already replied with an HTTP error.
+		log.WithError(err).Debug("Upgrading WebSocket connection")
+		return
+	}
+	conn := wirenet.NewIoConn(&wsConn{ws: ws})
+	if l.pinned {
+		// The handshake completed before the request was served.
+		id, err := pinnedPerunID(rawCertificates(r.TLS.PeerCertificates))
+		if err != nil {
+			log.WithError(err).Debug("Pinning WebSocket connection")
+			conn.Close()
+			return
+		}
+		conn = newPinnedConn(conn, nil, &id)
+	}
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+// Accept implements wirenet.Listener.Accept.
+func (l *wsListener) Accept() (wirenet.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errors.New("listener closed")
+	}
+}
+
+// Close implements wirenet.Listener.Close. Already accepted connections are
+// not closed.
+func (l *wsListener) Close() error {
+	err := errors.New("listener already closed")
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		err = l.server.Close()
+	})
+	return err
+}
+
+// Dial implements wirenet.Dialer.Dial.
+func (d *wsDialer) Dial(ctx context.Context, addr wire.Address) (wirenet.Conn, error) {
+	host, err := d.get(addr)
+	if err != nil {
+		return nil, err
+	}
+	dialer := d.dialer
+	if d.tls != nil {
+		dialer.TLSClientConfig = d.tls.client(addr)
+	}
+	u := url.URL{Scheme: d.scheme, Host: host, Path: wsPath}
+	ws, _, err := dialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "dialing %s", u.String())
+	}
+	conn := wirenet.NewIoConn(&wsConn{ws: ws})
+	if d.tls != nil && d.tls.pinned {
+		id := common.Address(*addr.(*ethwallet.Address))
+		return newPinnedConn(conn, nil, &id), nil
+	}
+	return conn, nil
+}
+
+// Close implements wirenet.Dialer.Close. WebSocket dialers hold no resources.
+func (d *wsDialer) Close() error {
+	return nil
+}
+
+// Read reads from the current binary message and moves on to the next one
+// once it is exhausted. Other message types are skipped.
+func (c *wsConn) Read(p []byte) (int, error) {
+	for {
+		if c.r == nil {
+			typ, r, err := c.ws.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			if typ != websocket.BinaryMessage {
+				continue
+			}
+			c.r = r
+		}
+		n, err := c.r.Read(p)
+		if err == io.EOF {
+			c.r = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Write sends `p` as one binary message.
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the underlying connection.
+func (c *wsConn) Close() error {
+	return c.ws.Close()
+}
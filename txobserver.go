@@ -0,0 +1,318 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/backend/ethereum/bindings/adjudicator"
+	"perun.network/go-perun/backend/ethereum/bindings/assetholder"
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+	"perun.network/go-perun/log"
+)
+
+// Purposes of transactions as returned by Transaction.GetPurpose.
+const (
+	// TxOther is any other transaction.
+	TxOther = iota
+	// TxDeploy deploys a contract.
+	TxDeploy
+	// TxDeposit funds a channel.
+	TxDeposit
+	// TxRegister registers or refutes a channel state.
+	TxRegister
+	// TxConclude concludes a channel.
+	TxConclude
+	// TxWithdraw withdraws the funds of a channel.
+	TxWithdraw
+	// TxTransfer transfers ETH or ERC-20 tokens, see Client.Transfer.
+	TxTransfer
+)
+
+const (
+	// txPollInterval is the interval in which the receipt and the
+	// confirmations of a transaction are checked.
+	txPollInterval = 2 * time.Second
+	// txTrackTimeout is the time after which a transaction that was not mined,
+	// or that was removed by a reorganization and not mined again, is not
+	// tracked anymore. Mined transactions are tracked until they have
+	// reorgDepthFactor times the required confirmations, regardless of it.
+	txTrackTimeout = time.Hour
+	// reorgDepthFactor determines how long a transaction is checked for
+	// reorganizations: until it has reorgDepthFactor times the required
+	// confirmations.
+	reorgDepthFactor = 2
+)
+
+type (
+	// TransactionObserver is notified about the on-chain transactions of the
+	// Client: once they are submitted, once they are mined, for every
+	// further confirmation until they are final and whenever they are
+	// affected by a chain reorganization.
+	TransactionObserver interface {
+		OnTransaction(*Transaction)
+	}
+
+	// Transaction is a snapshot of an on-chain transaction of the Client.
+	Transaction struct {
+		hash          string
+		purpose       int
+		gasUsed       uint64
+		confirmations uint64
+		required      uint64
+		mined, failed bool
+		reorgs        int // number of reorganizations that affected it
+	}
+
+	// txTracker is an ethchannel.ContractInterface that reports all sent
+	// transactions to a TransactionObserver.
+	txTracker struct {
+		ethchannel.ContractInterface
+		finalityDepth uint64
+		pollInterval  time.Duration
+		closed        <-chan struct{}
+
+		mutex    sync.Mutex
+		observer TransactionObserver // nil if not set
+		events   *eventEmitter       // nil until the Client is created
+	}
+)
+
+// contractMethods are the ABIs of the contracts whose methods are
+// recognized.
+var contractMethods = []abi.ABI{
+	mustParseABI(adjudicator.AdjudicatorABI),
+	mustParseABI(assetholder.AssetHolderABI),
+	erc20,
+}
+
+// setEvents sets the emitter of EventTxReorged.
+func (t *txTracker) setEvents(events *eventEmitter) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.events = events
+}
+
+// SetTransactionObserver sets an observer to be notified about on-chain
+// transactions. Only one such observer can be set at a time, and repeated
+// calls to this function will overwrite the currently existing observer. This
+// function may be safely called at any time.
+func (c *Client) SetTransactionObserver(o TransactionObserver) {
+	c.txs.mutex.Lock()
+	defer c.txs.mutex.Unlock()
+	c.txs.observer = o
+}
+
+// newTxTracker returns a txTracker that wraps `backend`. Transactions are
+// tracked until they are `finalityDepth` blocks deep or `closed` is closed.
+func newTxTracker(backend ethchannel.ContractInterface, finalityDepth uint64, closed <-chan struct{}) *txTracker {
+	return &txTracker{
+		ContractInterface: backend,
+		finalityDepth:     finalityDepth,
+		pollInterval:      txPollInterval,
+		closed:            closed,
+	}
+}
+
+// SendTransaction sends `tx` and tracks it.
+func (t *txTracker) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	if err := t.ContractInterface.SendTransaction(ctx, tx); err != nil {
+		return err
+	}
+	snapshot := Transaction{hash: tx.Hash().Hex(), purpose: txPurpose(tx), required: t.finalityDepth}
+	t.report(snapshot)
+	go t.track(tx, snapshot)
+	return nil
+}
+
+// track reports the receipt and the confirmations of `tx`. After the
+// transaction is final, it is still checked for reorganizations until it has
+// reorgDepthFactor times the required confirmations. While it is not mined,
+// it is given up after txTrackTimeout.
+func (t *txTracker) track(tx *types.Transaction, snapshot Transaction) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-t.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	deadline := time.After(txTrackTimeout) // nil while mined
+	var block common.Hash                  // block that includes tx, zero if not mined
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline:
+			log.WithField("tx", snapshot.hash).Warn("Stopped tracking unconfirmed transaction")
+			return
+		case <-time.After(t.pollInterval):
+		}
+
+		receipt, err := t.TransactionReceipt(ctx, tx.Hash())
+		if err == nil && receipt == nil {
+			err = ethereum.NotFound
+		}
+		switch {
+		case errors.Is(err, ethereum.NotFound) && snapshot.mined:
+			// The block of tx is not canonical anymore.
+			snapshot.mined, snapshot.confirmations, block = false, 0, common.Hash{}
+			deadline = time.After(txTrackTimeout)
+			t.reorged(ctx, tx, &snapshot, true)
+			continue
+		case err != nil:
+			continue // Not mined yet or temporary error.
+		case snapshot.mined && receipt.BlockHash != block:
+			// tx was included in another block.
+			t.reorged(ctx, tx, &snapshot, false)
+		}
+		deadline = nil
+		block = receipt.BlockHash
+		snapshot.mined = true
+		snapshot.gasUsed = receipt.GasUsed
+		snapshot.failed = receipt.Status != types.ReceiptStatusSuccessful
+
+		head, err := t.HeaderByNumber(ctx, nil)
+		if err != nil || head.Number.Cmp(receipt.BlockNumber) < 0 {
+			continue
+		}
+		confirmations := head.Number.Uint64() - receipt.BlockNumber.Uint64() + 1
+		if confirmations == snapshot.confirmations {
+			continue
+		}
+		if snapshot.confirmations < snapshot.required || confirmations < snapshot.confirmations {
+			snapshot.confirmations = confirmations
+			t.report(snapshot)
+		}
+		snapshot.confirmations = confirmations
+		if confirmations >= reorgDepthFactor*snapshot.required {
+			return
+		}
+	}
+}
+
+// reorged reports that `tx` was affected by a reorganization. If it was
+// removed from the chain, it is resubmitted. This is safe since a signed
+// transaction can only be included once.
+func (t *txTracker) reorged(ctx context.Context, tx *types.Transaction, snapshot *Transaction, removed bool) {
+	snapshot.reorgs++
+	log.WithField("tx", snapshot.hash).WithField("removed", removed).Warn("Transaction affected by chain reorganization")
+	t.report(*snapshot)
+	t.mutex.Lock()
+	events := t.events
+	t.mutex.Unlock()
+	if events != nil {
+		events.emit(&Event{typ: EventTxReorged, txHash: snapshot.hash})
+	}
+	if !removed {
+		return
+	}
+	if err := t.ContractInterface.SendTransaction(ctx, tx); err != nil {
+		// The node may still know it from the reorged block.
+		log.WithError(err).WithField("tx", snapshot.hash).Debug("Resubmitting reorged transaction")
+	}
+}
+
+// report passes a copy of `snapshot` to the TransactionObserver.
+func (t *txTracker) report(snapshot Transaction) {
+	t.mutex.Lock()
+	o := t.observer
+	t.mutex.Unlock()
+	if o != nil {
+		o.OnTransaction(&snapshot)
+	}
+}
+
+// txPurpose classifies `tx` by the contract method that it calls.
+func txPurpose(tx *types.Transaction) int {
+	if tx.To() == nil {
+		return TxDeploy
+	}
+	if len(tx.Data()) == 0 {
+		return TxTransfer
+	}
+	if len(tx.Data()) < 4 {
+		return TxOther
+	}
+	for _, parsed := range contractMethods {
+		m, err := parsed.MethodById(tx.Data()[:4])
+		if err != nil {
+			continue
+		}
+		switch m.Name {
+		case "deposit":
+			return TxDeposit
+		case "register", "refute":
+			return TxRegister
+		case "conclude", "concludeFinal":
+			return TxConclude
+		case "withdraw":
+			return TxWithdraw
+		case "transfer":
+			return TxTransfer
+		}
+	}
+	return TxOther
+}
+
+// GetHash returns the hex encoded transaction hash.
+func (t *Transaction) GetHash() string {
+	return t.hash
+}
+
+// GetPurpose returns the purpose of the transaction, one of the Tx*
+// constants.
+func (t *Transaction) GetPurpose() int {
+	return t.purpose
+}
+
+// IsMined returns whether the transaction was included in a block.
+func (t *Transaction) IsMined() bool {
+	return t.mined
+}
+
+// IsFailed returns whether the transaction was mined but reverted.
+func (t *Transaction) IsFailed() bool {
+	return t.failed
+}
+
+// GetGasUsed returns the gas used by the mined transaction or 0.
+func (t *Transaction) GetGasUsed() int64 {
+	return int64(t.gasUsed)
+}
+
+// GetReorgs returns the number of chain reorganizations that removed the
+// transaction from the chain or moved it to another block.
+func (t *Transaction) GetReorgs() int {
+	return t.reorgs
+}
+
+// GetConfirmations returns the number of blocks that include or follow the
+// block of the transaction.
+func (t *Transaction) GetConfirmations() int {
+	return int(t.confirmations)
+}
+
+// GetRequiredConfirmations returns the number of confirmations after which
+// the transaction is considered final, see Config.TxFinalityDepth.
+func (t *Transaction) GetRequiredConfirmations() int {
+	return int(t.required)
+}
+
+// IsFinal returns whether the transaction has the required confirmations.
+func (t *Transaction) IsFinal() bool {
+	return t.mined && t.confirmations >= t.required
+}
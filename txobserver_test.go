@@ -0,0 +1,107 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// txObserver passes all reported transactions to a channel.
+type txObserver chan *Transaction
+
+func (o txObserver) OnTransaction(tx *Transaction) { o <- tx }
+
+// await returns the first reported transaction that satisfies `cond`.
+func (o txObserver) await(t *testing.T, cond func(*Transaction) bool) *Transaction {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case tx := <-o:
+			if cond(tx) {
+				return tx
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for transaction report")
+		}
+	}
+}
+
+func TestTxTrackerReorg(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	sim := backends.NewSimulatedBackend(core.GenesisAlloc{from: {Balance: big.NewInt(1e18)}}, 8_000_000)
+	defer sim.Close()
+	genesis := sim.Blockchain().CurrentBlock().Hash()
+
+	closed := make(chan struct{})
+	defer close(closed)
+	tr := newTxTracker(sim, 2, closed)
+	tr.pollInterval = 10 * time.Millisecond
+	o := make(txObserver, 100)
+	tr.observer = o
+
+	tx, err := types.SignTx(
+		types.NewTransaction(0, common.Address{1}, big.NewInt(1), 21000, big.NewInt(1e9), nil),
+		types.NewEIP155Signer(big.NewInt(1337)), key)
+	if err != nil {
+		t.Fatalf("signing transaction: %v", err)
+	}
+	if err := tr.SendTransaction(ctx, tx); err != nil {
+		t.Fatalf("sending transaction: %v", err)
+	}
+	if s := o.await(t, func(*Transaction) bool { return true }); s.GetPurpose() != TxTransfer || s.IsMined() {
+		t.Fatalf("unexpected snapshot after sending: %+v", s)
+	}
+	sim.Commit()
+	o.await(t, (*Transaction).IsMined)
+
+	// Replace the block of tx by a longer chain without it.
+	if err := sim.Fork(ctx, genesis); err != nil {
+		t.Fatalf("forking chain: %v", err)
+	}
+	sim.Commit()
+	sim.Commit()
+	if s := o.await(t, func(s *Transaction) bool { return s.GetReorgs() > 0 }); s.IsMined() {
+		t.Fatalf("removed transaction reported as mined: %+v", s)
+	}
+
+	// The tracker resubmits tx to the new chain.
+	for {
+		nonce, err := sim.PendingNonceAt(ctx, from)
+		if err != nil {
+			t.Fatalf("reading pending nonce: %v", err)
+		}
+		if nonce == 1 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("reorged transaction was not resubmitted")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	sim.Commit()
+	o.await(t, (*Transaction).IsMined)
+	sim.Commit()
+	if s := o.await(t, (*Transaction).IsFinal); s.GetReorgs() != 1 {
+		t.Fatalf("expected one reorganization, got %d", s.GetReorgs())
+	}
+}
@@ -30,20 +30,21 @@ func NewWallet(path, password string) (*Wallet, error) {
 	// it is quite slow to use the standard parameters. Do not to this in production.
 	ks := ethkeystore.NewKeyStore(path, 2, 1)
 	w, err := keystore.NewWallet(ks, password)
-	return &Wallet{w: w, password: password}, errors.WithMessage(err, "creating wallet")
+	return &Wallet{w: w, password: password}, toError(errors.WithMessage(err, "creating wallet"))
 }
 
 // ImportAccount imports an Ethereum secret key into the Wallet and
 // returns the corresponding Address of it. Secret key example:
 // 0x6aeeb7f09e757baa9d3935a042c3d0d46a2eda19e9b676283dce4eaf32e29dc9
 // Accounts can safely be imported more than once.
-func (w *Wallet) ImportAccount(secretKey string) (*Address, error) {
+func (w *Wallet) ImportAccount(secretKey string) (_ *Address, err error) {
+	defer func() { err = toError(err) }()
 	if len(secretKey) != 66 || secretKey[:2] != "0x" {
-		return nil, errors.New("Secret key must start with 0x and be 66 characters long")
+		return nil, newError(ErrorInvalidArgument, "", errors.New("Secret key must start with 0x and be 66 characters long"))
 	}
 	sk, err := crypto.HexToECDSA(secretKey[2:])
 	if err != nil {
-		return nil, errors.WithMessage(err, "decoding secret key")
+		return nil, newError(ErrorInvalidArgument, "", errors.WithMessage(err, "decoding secret key"))
 	}
 	var ethAcc accounts.Account
 	addr := crypto.PubkeyToAddress(sk.PublicKey)
@@ -0,0 +1,156 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/client"
+	"perun.network/go-perun/log"
+)
+
+const (
+	// minWatcherBackoff and maxWatcherBackoff bound the waiting time between
+	// restarts of a failed watcher.
+	minWatcherBackoff = time.Second
+	maxWatcherBackoff = time.Minute
+	// maxWatcherRestarts is the number of consecutive restarts after which a
+	// watcher is considered failed persistently. A watcher that ran for
+	// longer than maxWatcherBackoff before failing resets the count.
+	maxWatcherRestarts = 8
+)
+
+type (
+	// WatcherFailureHandler is notified when the watcher of a channel failed
+	// persistently and was not restarted anymore. The channel is not
+	// protected against disputes until the app intervenes, e.g. by settling
+	// it.
+	WatcherFailureHandler interface {
+		OnWatcherFailed(channelID []byte, reason string)
+	}
+
+	// channelWatcher is the supervised watcher of a channel. It passes the
+	// adjudicator events to the EventListener and to the handlers that were
+	// added with PaymentChannel.Watch.
+	channelWatcher struct {
+		events *eventEmitter
+
+		mutex    sync.Mutex
+		handlers []client.AdjudicatorEventHandler
+
+		done     chan struct{} // closed when the supervision ended
+		err      error         // set before done is closed
+		stopped  chan struct{} // closed by stop
+		stopOnce sync.Once
+	}
+)
+
+// SetWatcherFailureHandler sets a handler to be notified about watchers that
+// failed persistently. Only one such handler can be set at a time, and
+// repeated calls to this function will overwrite the currently existing
+// handler. This function may be safely called at any time.
+func (c *Client) SetWatcherFailureHandler(h WatcherFailureHandler) {
+	c.channelsMutex.Lock()
+	defer c.channelsMutex.Unlock()
+	c.onWatcherFailed = h
+}
+
+// newChannelWatcher returns a channelWatcher that emits events to `events`.
+func newChannelWatcher(events *eventEmitter) *channelWatcher {
+	return &channelWatcher{events: events, done: make(chan struct{}), stopped: make(chan struct{})}
+}
+
+// stop ends the supervision once the channel was closed. Failures of the
+// watcher are not reported anymore.
+func (w *channelWatcher) stop() {
+	w.stopOnce.Do(func() { close(w.stopped) })
+}
+
+// supervise watches the channel `pch` until it or the Client is closed. A
+// failed watcher is restarted with exponential backoff until it failed
+// maxWatcherRestarts times in a row, which is reported to the
+// WatcherFailureHandler.
+func (c *Client) supervise(pch *PaymentChannel) {
+	w := pch.watcher
+	defer close(w.done)
+	backoff := minWatcherBackoff
+	failures := 0
+	for {
+		started := time.Now()
+		err := pch.ch.Watch(w)
+		if err == nil {
+			return
+		}
+		select {
+		case <-c.closed:
+			w.err = err
+			return
+		case <-w.stopped:
+			w.err = err
+			return
+		default:
+		}
+		c.events.emit(&Event{typ: EventWatcherError, id: pch.ch.ID(), err: err})
+
+		if time.Since(started) > maxWatcherBackoff {
+			failures, backoff = 0, minWatcherBackoff
+		}
+		if failures++; failures > maxWatcherRestarts {
+			w.err = errors.WithMessage(err, "watcher failed persistently")
+			c.watcherFailed(pch.ch.ID(), w.err)
+			return
+		}
+		log.WithError(err).WithField("channel", pch.ch.ID()).Warnf("Restarting watcher in %v", backoff)
+		select {
+		case <-c.closed:
+			w.err = err
+			return
+		case <-w.stopped:
+			w.err = err
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxWatcherBackoff {
+			backoff = maxWatcherBackoff
+		}
+	}
+}
+
+// watcherFailed reports a persistently failed watcher.
+func (c *Client) watcherFailed(id channel.ID, err error) {
+	log.WithError(err).WithField("channel", id).Error("Watcher failed")
+	c.channelsMutex.Lock()
+	h := c.onWatcherFailed
+	c.channelsMutex.Unlock()
+	if h != nil {
+		h.OnWatcherFailed(id[:], err.Error())
+	}
+}
+
+// wait adds `h` to the handlers of adjudicator events and blocks until the
+// supervision of the channel ended.
+func (w *channelWatcher) wait(h client.AdjudicatorEventHandler) error {
+	w.mutex.Lock()
+	w.handlers = append(w.handlers, h)
+	w.mutex.Unlock()
+	<-w.done
+	return w.err
+}
+
+// HandleAdjudicatorEvent handles channel events emitted by the Adjudicator.
+func (w *channelWatcher) HandleAdjudicatorEvent(e channel.AdjudicatorEvent) {
+	w.events.emitAdjudicatorEvent(e)
+	w.mutex.Lock()
+	handlers := append([]client.AdjudicatorEventHandler(nil), w.handlers...)
+	w.mutex.Unlock()
+	for _, h := range handlers {
+		h.HandleAdjudicatorEvent(e)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prnm
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/watcher"
+
+	"github.com/perun-network/perun-eth-mobile/watchtower"
+)
+
+type (
+	// towerWatcher wraps a watcher.Watcher and additionally hands every
+	// signed state of the watched channels to a watchtower.
+	towerWatcher struct {
+		watcher.Watcher
+		tower       *watchtower.Client
+		adjudicator common.Address
+	}
+
+	// towerStatesPub forwards the published states of a channel to the
+	// watchtower.
+	towerStatesPub struct {
+		watcher.StatesPub
+		w      *towerWatcher
+		params *channel.Params
+	}
+)
+
+// StartWatchingLedgerChannel starts watching the ledger channel of
+// `signedState` and submits the state to the watchtower.
+func (w *towerWatcher) StartWatchingLedgerChannel(ctx context.Context, signedState channel.SignedState) (watcher.StatesPub, watcher.AdjudicatorSub, error) {
+	pub, sub, err := w.Watcher.StartWatchingLedgerChannel(ctx, signedState)
+	if err != nil {
+		return nil, nil, err
+	}
+	w.submit(signedState.Params, channel.Transaction{State: signedState.State, Sigs: signedState.Sigs})
+	return &towerStatesPub{StatesPub: pub, w: w, params: signedState.Params}, sub, nil
+}
+
+// Publish publishes `tx` to the wrapped watcher and submits it to the
+// watchtower.
+func (p *towerStatesPub) Publish(ctx context.Context, tx channel.Transaction) error {
+	err := p.StatesPub.Publish(ctx, tx)
+	p.w.submit(p.params, tx)
+	return err
+}
+
+// submit hands `tx` to the watchtower in the background.
+func (w *towerWatcher) submit(params *channel.Params, tx channel.Transaction) {
+	sub, err := watchtower.NewSubmission(w.adjudicator, params, tx)
+	if err != nil {
+		log.WithError(err).Warn("Not submitting state to watchtower")
+		return
+	}
+	w.tower.Submit(sub)
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package watchtower
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/log"
+)
+
+const (
+	// submitTimeout limits the time that a single submission may take.
+	submitTimeout = 30 * time.Second
+	// minRetryBackoff and maxRetryBackoff bound the waiting time between
+	// attempts to submit a state.
+	minRetryBackoff = time.Second
+	maxRetryBackoff = time.Minute
+)
+
+// Client submits states to a watchtower. Submissions are sent in the
+// background and retried until they succeed or a newer state of the same
+// channel supersedes them.
+type Client struct {
+	url  string
+	http http.Client
+
+	mutex   sync.Mutex
+	pending map[common.Hash]*Submission // latest unsent submission per channel
+	sending map[common.Hash]bool        // channels with a running sender
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewClient returns a Client for the watchtower at `url`, e.g.
+// http://10.5.0.12:5770.
+func NewClient(url string) *Client {
+	return &Client{
+		url:     strings.TrimSuffix(url, "/") + StatesPath,
+		http:    http.Client{Timeout: submitTimeout},
+		pending: make(map[common.Hash]*Submission),
+		sending: make(map[common.Hash]bool),
+		closed:  make(chan struct{}),
+	}
+}
+
+// Submit sends `sub` to the watchtower in the background.
+func (c *Client) Submit(sub *Submission) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if old, ok := c.pending[sub.ChannelID]; ok && old.Version >= sub.Version {
+		return
+	}
+	c.pending[sub.ChannelID] = sub
+	if !c.sending[sub.ChannelID] {
+		c.sending[sub.ChannelID] = true
+		go c.send(sub.ChannelID)
+	}
+}
+
+// Close stops sending submissions. Pending submissions are dropped.
+func (c *Client) Close() {
+	c.closeOnce.Do(func() { close(c.closed) })
+}
+
+// send submits the pending submissions of channel `id` until there are none
+// left.
+func (c *Client) send(id common.Hash) {
+	backoff := minRetryBackoff
+	for {
+		c.mutex.Lock()
+		sub, ok := c.pending[id]
+		delete(c.pending, id)
+		if !ok {
+			c.sending[id] = false
+			c.mutex.Unlock()
+			return
+		}
+		c.mutex.Unlock()
+
+		err := c.post(sub)
+		if err == nil {
+			backoff = minRetryBackoff
+			continue
+		}
+		log.WithError(err).WithField("channel", id.Hex()).Warnf("Submitting state to watchtower, retrying in %v", backoff)
+		c.Submit(sub) // Only requeued if not superseded.
+		select {
+		case <-c.closed:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// post sends `sub` to the watchtower.
+func (c *Client) post(sub *Submission) error {
+	data, err := json.Marshal(sub)
+	if err != nil {
+		return errors.Wrap(err, "encoding submission")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), submitTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "sending submission")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("watchtower responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,355 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package watchtower
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+	"perun.network/go-perun/backend/ethereum/wallet/keystore"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/log"
+)
+
+const (
+	// StatesPath is the HTTP path that submissions are posted to.
+	StatesPath = "/v1/states"
+	// maxSubmissionSize limits the size of a submission in bytes.
+	maxSubmissionSize = 1 << 16
+	// registerTimeout limits the time that registering a state may take.
+	registerTimeout = 5 * time.Minute
+	// minSubscribeBackoff and maxSubscribeBackoff bound the waiting time
+	// between subscriptions to the adjudicator events of a channel.
+	minSubscribeBackoff = time.Second
+	maxSubscribeBackoff = time.Minute
+	// maxClientSubmissions and maxChannelSubmissions limit the submissions
+	// of a client, identified by its IP address, and of a channel within
+	// submissionWindow.
+	maxClientSubmissions  = 600
+	maxChannelSubmissions = 120
+	submissionWindow      = time.Minute
+	// maxChannels limits the number of watched channels.
+	maxChannels = 10000
+)
+
+var (
+	// errTooManySubmissions is returned if a client or channel exceeded its
+	// limit of submissions.
+	errTooManySubmissions = errors.New("too many submissions")
+	// errTooManyChannels is returned if a submission of a new channel would
+	// exceed maxChannels.
+	errTooManyChannels = errors.New("too many channels")
+)
+
+type (
+	// Server is a watchtower. It accepts submissions over HTTP, stores the
+	// latest one of every channel and registers it whenever an older state is
+	// registered on-chain. The transactions are sent from the account of the
+	// watchtower. Only channels of a single Adjudicator are accepted.
+	Server struct {
+		acc     *keystore.Account
+		adjAddr common.Address
+		adj     *ethchannel.Adjudicator
+		dir     string // directory of the stored submissions
+
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		mutex        sync.Mutex
+		channels     map[channel.ID]*Submission // latest submissions
+		clientLimit  limiter
+		channelLimit limiter
+	}
+
+	// limiter counts events per key within fixed time windows.
+	limiter struct {
+		max    int
+		start  time.Time
+		counts map[string]int
+	}
+)
+
+// NewServer returns a watchtower that watches channels of the Adjudicator at
+// `adjudicator`, sends transactions with `acc` over `cb` and stores
+// submissions in the directory `dir`. Channels of stored submissions are
+// watched immediately.
+func NewServer(cb ethchannel.ContractBackend, acc *keystore.Account, adjudicator common.Address, dir string) (*Server, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "creating directory")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		acc:          acc,
+		adjAddr:      adjudicator,
+		adj:          ethchannel.NewAdjudicator(cb, adjudicator, acc.Account.Address, acc.Account),
+		dir:          dir,
+		ctx:          ctx,
+		cancel:       cancel,
+		channels:     make(map[channel.ID]*Submission),
+		clientLimit:  limiter{max: maxClientSubmissions},
+		channelLimit: limiter{max: maxChannelSubmissions},
+	}
+	if err := s.load(); err != nil {
+		cancel()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close stops watching all channels.
+func (s *Server) Close() {
+	s.cancel()
+}
+
+// NumChannels returns the number of watched channels.
+func (s *Server) NumChannels() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.channels)
+}
+
+// ServeHTTP accepts submissions that are posted to StatesPath.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != StatesPath {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	var sub Submission
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSubmissionSize)).Decode(&sub); err != nil {
+		http.Error(w, "malformed submission", http.StatusBadRequest)
+		return
+	}
+	if err := s.Submit(client, &sub); errors.Is(err, errTooManySubmissions) {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	} else if errors.Is(err, errTooManyChannels) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Submit stores `sub` of `client` if it is newer than the stored submission
+// of its channel and starts watching the channel if it is new. The state must
+// be signed by all participants and belong to the Adjudicator of the server.
+// Every client and channel may only submit a limited number of states per
+// minute, and at most maxChannels channels are watched.
+func (s *Server) Submit(client string, sub *Submission) error {
+	if err := s.check(sub); err != nil {
+		return errors.WithMessage(err, "invalid submission")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	if !s.clientLimit.allow(client, now) || !s.channelLimit.allow(sub.ChannelID.Hex(), now) {
+		return errTooManySubmissions
+	}
+	return s.add(sub)
+}
+
+// check checks that `sub` belongs to the Adjudicator of the server and is
+// signed by all participants.
+func (s *Server) check(sub *Submission) error {
+	if sub.Adjudicator != s.adjAddr {
+		return errors.Errorf("adjudicator %s is not watched", sub.Adjudicator.Hex())
+	}
+	return sub.verify()
+}
+
+// add stores `sub` if it is newer than the stored submission of its channel
+// and starts watching the channel if it is new. The mutex must be held.
+func (s *Server) add(sub *Submission) error {
+	id := channel.ID(sub.ChannelID)
+	old, ok := s.channels[id]
+	if ok && old.Version >= sub.Version {
+		return nil
+	}
+	if !ok && len(s.channels) >= maxChannels {
+		return errTooManyChannels
+	}
+	if err := s.store(sub); err != nil {
+		log.WithError(err).Error("Storing submission")
+		return errors.New("internal error")
+	}
+	s.channels[id] = sub
+	if !ok {
+		log.WithField("channel", sub.ChannelID.Hex()).Info("Watching channel")
+		go s.watch(id)
+	}
+	return nil
+}
+
+// watch subscribes to the adjudicator events of channel `id` and handles them
+// until the channel is concluded or the server is closed.
+func (s *Server) watch(id channel.ID) {
+	backoff := minSubscribeBackoff
+	for {
+		sub := s.latest(id)
+		if sub == nil {
+			return
+		}
+		events, err := s.adj.Subscribe(s.ctx, id)
+		if err == nil {
+			backoff = minSubscribeBackoff
+			for e := events.Next(); e != nil; e = events.Next() {
+				if s.handle(e) {
+					events.Close()
+					return
+				}
+			}
+			err = events.Err()
+			events.Close()
+		}
+		if s.ctx.Err() != nil {
+			return
+		}
+		log.WithError(err).WithField("channel", sub.ChannelID.Hex()).Warn("Subscribing to adjudicator events")
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxSubscribeBackoff {
+			backoff = maxSubscribeBackoff
+		}
+	}
+}
+
+// handle registers the latest state if `e` refers to an older one. It
+// returns whether the channel is concluded and does not need to be watched
+// anymore.
+func (s *Server) handle(e channel.AdjudicatorEvent) bool {
+	id := e.ID()
+	lg := log.WithField("channel", hex.EncodeToString(id[:]))
+	if _, ok := e.(*channel.ConcludedEvent); ok {
+		lg.Info("Channel concluded")
+		s.forget(id)
+		return true
+	}
+	sub := s.latest(id)
+	if sub == nil || e.Version() >= sub.Version {
+		return false
+	}
+
+	lg.WithField("registered", e.Version()).WithField("latest", sub.Version).Warn("Outdated state registered, refuting")
+	params, tx, err := sub.SignedState()
+	if err != nil {
+		lg.WithError(err).Error("Reconstructing latest state")
+		return false
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, registerTimeout)
+	defer cancel()
+	req := channel.AdjudicatorReq{Params: params, Acc: s.acc, Tx: tx}
+	if err := s.adj.Register(ctx, req, nil); err != nil {
+		lg.WithError(err).Error("Registering latest state")
+	}
+	return false
+}
+
+// latest returns the latest submission of channel `id` or nil.
+func (s *Server) latest(id channel.ID) *Submission {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.channels[id]
+}
+
+// forget stops watching channel `id` and deletes its submission.
+func (s *Server) forget(id channel.ID) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.channels, id)
+	if err := os.Remove(s.path(id)); err != nil {
+		log.WithError(err).Warn("Deleting submission")
+	}
+}
+
+// store writes `sub` atomically to the directory of the server.
+func (s *Server) store(sub *Submission) error {
+	data, err := json.Marshal(sub)
+	if err != nil {
+		return errors.Wrap(err, "encoding submission")
+	}
+	path := s.path(channel.ID(sub.ChannelID))
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return errors.Wrap(err, "writing submission")
+	}
+	return errors.Wrap(os.Rename(path+".tmp", path), "renaming submission")
+}
+
+// load reads the stored submissions and starts watching their channels.
+// Submissions that cannot be loaded are skipped.
+func (s *Server) load() error {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return errors.Wrap(err, "listing submissions")
+	}
+	for _, path := range paths {
+		if err := s.loadFile(path); err != nil {
+			log.WithError(err).WithField("file", filepath.Base(path)).Error("Skipping stored submission")
+		}
+	}
+	return nil
+}
+
+// loadFile reads the submission at `path` and starts watching its channel.
+func (s *Server) loadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "reading submission")
+	}
+	var sub Submission
+	if err := json.Unmarshal(data, &sub); err != nil {
+		return errors.Wrap(err, "decoding submission")
+	}
+	if err := s.check(&sub); err != nil {
+		return errors.WithMessage(err, "invalid submission")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.add(&sub)
+}
+
+// allow counts an event of `key` at `now` and returns whether the limit of
+// the current window is not exceeded.
+func (l *limiter) allow(key string, now time.Time) bool {
+	if now.Sub(l.start) >= submissionWindow {
+		l.start, l.counts = now, make(map[string]int)
+	}
+	if l.counts[key] >= l.max {
+		return false
+	}
+	l.counts[key]++
+	return true
+}
+
+// path returns the file path of the submission of channel `id`.
+func (s *Server) path(id channel.ID) string {
+	return filepath.Join(s.dir, hex.EncodeToString(id[:])+".json")
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package watchtower
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ethkeystore "github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+
+	_ "perun.network/go-perun/backend/ethereum" // backend init
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+	ethchanneltest "perun.network/go-perun/backend/ethereum/channel/test"
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/backend/ethereum/wallet/keystore"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/wallet"
+)
+
+// challengeDuration is long enough to not elapse on the simulated chain,
+// whose block times advance by ten seconds per block.
+const challengeDuration = 1 << 24
+
+func TestServerRefutesOutdatedState(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	sb := ethchanneltest.NewSimulatedBackend()
+	sb.StartMining(10 * time.Millisecond)
+	defer sb.StopMining()
+
+	w, err := keystore.NewWallet(ethkeystore.NewKeyStore(t.TempDir(), ethkeystore.LightScryptN, ethkeystore.LightScryptP), "")
+	if err != nil {
+		t.Fatalf("creating wallet: %v", err)
+	}
+	alice, bob, tower := w.NewAccount(), w.NewAccount(), w.NewAccount()
+	for _, acc := range []*keystore.Account{alice, bob, tower} {
+		sb.FundAddress(ctx, acc.Account.Address)
+	}
+	cb := ethchannel.NewContractBackend(sb, keystore.NewTransactor(*w, types.NewEIP155Signer(big.NewInt(1337))), 1)
+	adjAddr, err := ethchannel.DeployAdjudicator(ctx, cb, tower.Account)
+	if err != nil {
+		t.Fatalf("deploying adjudicator: %v", err)
+	}
+
+	s, err := NewServer(cb, tower, adjAddr, t.TempDir())
+	if err != nil {
+		t.Fatalf("creating server: %v", err)
+	}
+	defer s.Close()
+
+	params, err := channel.NewParams(challengeDuration, []wallet.Address{alice.Address(), bob.Address()}, channel.NoApp(), big.NewInt(1), true, false)
+	if err != nil {
+		t.Fatalf("creating params: %v", err)
+	}
+	submission := func(version uint64, bals ...int64) *Submission {
+		state := &channel.State{
+			ID:      params.ID(),
+			Version: version,
+			App:     channel.NoApp(),
+			Allocation: channel.Allocation{
+				Assets:   []channel.Asset{ethwallet.AsWalletAddr(common.Address{1})},
+				Balances: channel.Balances{{big.NewInt(bals[0]), big.NewInt(bals[1])}},
+			},
+			Data: channel.NoData(),
+		}
+		tx := channel.Transaction{State: state}
+		for _, acc := range []*keystore.Account{alice, bob} {
+			sig, err := channel.Sign(acc, state)
+			if err != nil {
+				t.Fatalf("signing state: %v", err)
+			}
+			tx.Sigs = append(tx.Sigs, sig)
+		}
+		sub, err := NewSubmission(adjAddr, params, tx)
+		if err != nil {
+			t.Fatalf("creating submission: %v", err)
+		}
+		return sub
+	}
+	latest := func() uint64 {
+		if sub := s.latest(params.ID()); sub != nil {
+			return sub.Version
+		}
+		return 0
+	}
+
+	old := submission(1, 10, 10)
+	if err := s.Submit("client", old); err != nil {
+		t.Fatalf("submitting valid state: %v", err)
+	}
+	forged := submission(2, 10, 10)
+	forged.Balances[0] = (*hexutil.Big)(big.NewInt(20))
+	if err := s.Submit("client", forged); err == nil {
+		t.Fatal("forged state was accepted")
+	}
+	if v := latest(); v != 1 {
+		t.Fatalf("latest version after forged state is %d, expected 1", v)
+	}
+	other := submission(2, 10, 10)
+	other.Adjudicator = common.Address{2}
+	if err := s.Submit("client", other); err == nil {
+		t.Fatal("state of other adjudicator was accepted")
+	}
+	if err := s.Submit("client", submission(3, 5, 15)); err != nil {
+		t.Fatalf("submitting valid state: %v", err)
+	}
+	if err := s.Submit("client", submission(2, 8, 12)); err != nil {
+		t.Fatalf("submitting older state: %v", err)
+	}
+	if v := latest(); v != 3 {
+		t.Fatalf("latest version after older state is %d, expected 3", v)
+	}
+
+	// Alice registers the outdated state, the tower must refute it.
+	adj := ethchannel.NewAdjudicator(cb, adjAddr, alice.Account.Address, alice.Account)
+	events, err := adj.Subscribe(ctx, params.ID())
+	if err != nil {
+		t.Fatalf("subscribing to adjudicator events: %v", err)
+	}
+	defer events.Close()
+	go func() {
+		<-ctx.Done()
+		events.Close()
+	}()
+	_, oldTx, err := old.SignedState()
+	if err != nil {
+		t.Fatalf("reconstructing outdated state: %v", err)
+	}
+	req := channel.AdjudicatorReq{Params: params, Acc: alice, Idx: 0, Tx: oldTx}
+	if err := adj.Register(ctx, req, nil); err != nil {
+		t.Fatalf("registering outdated state: %v", err)
+	}
+	for e := events.Next(); e != nil; e = events.Next() {
+		if _, ok := e.(*channel.RegisteredEvent); ok && e.Version() == 3 {
+			return
+		}
+	}
+	t.Fatalf("latest state was not registered: %v", events.Err())
+}
+
+func TestServerSkipsInvalidStoredSubmissions(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"corrupt.json": "{",
+		"other.json":   `{"adjudicator":"0x0200000000000000000000000000000000000000"}`,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatalf("writing submission: %v", err)
+		}
+	}
+	s, err := NewServer(ethchannel.ContractBackend{}, new(keystore.Account), common.Address{1}, dir)
+	if err != nil {
+		t.Fatalf("creating server: %v", err)
+	}
+	defer s.Close()
+	if n := s.NumChannels(); n != 0 {
+		t.Fatalf("watching %d channels, expected none", n)
+	}
+}
+
+func TestServerLimitsChannels(t *testing.T) {
+	s, err := NewServer(ethchannel.ContractBackend{}, new(keystore.Account), common.Address{1}, t.TempDir())
+	if err != nil {
+		t.Fatalf("creating server: %v", err)
+	}
+	defer s.Close()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := 0; i < maxChannels; i++ {
+		var id channel.ID
+		binary.BigEndian.PutUint64(id[:], uint64(i))
+		s.channels[id] = &Submission{ChannelID: id, Version: 1}
+	}
+	if err := s.add(&Submission{ChannelID: common.Hash{1}, Version: 1}); !errors.Is(err, errTooManyChannels) {
+		t.Fatalf("adding channel above the limit returned %v", err)
+	}
+	// Newer states of watched channels are still accepted.
+	if err := s.add(&Submission{ChannelID: common.Hash{}, Version: 2}); err != nil {
+		t.Fatalf("updating watched channel: %v", err)
+	}
+}
+
+func TestServerLimitsSubmissions(t *testing.T) {
+	l := limiter{max: 2}
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if !l.allow("client", now) {
+			t.Fatalf("submission %d was limited", i)
+		}
+	}
+	if l.allow("client", now) {
+		t.Fatal("submission above the limit was allowed")
+	}
+	if !l.allow("other", now) {
+		t.Fatal("submission of other client was limited")
+	}
+	if !l.allow("client", now.Add(submissionWindow)) {
+		t.Fatal("submission in the next window was limited")
+	}
+}
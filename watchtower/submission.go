@@ -0,0 +1,127 @@
+// Copyright (c) 2021 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of
+// perun-eth-mobile. Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package watchtower implements a watchtower that protects payment channels
+// of clients that are offline during a dispute. Clients submit every signed
+// channel state to the watchtower, which registers the latest one whenever an
+// older state is registered on-chain.
+package watchtower
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
+
+	ethwallet "perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/wallet"
+)
+
+// Submission is a signed state of a two-party single-asset ledger payment
+// channel, as submitted to the watchtower.
+type Submission struct {
+	Adjudicator       common.Address   `json:"adjudicator"`
+	ChannelID         common.Hash      `json:"channelID"`
+	ChallengeDuration uint64           `json:"challengeDuration"`
+	Parts             []common.Address `json:"parts"`
+	Nonce             *hexutil.Big     `json:"nonce"`
+	Version           uint64           `json:"version"`
+	Asset             common.Address   `json:"asset"`
+	Balances          []*hexutil.Big   `json:"balances"`
+	IsFinal           bool             `json:"isFinal"`
+	Sigs              []hexutil.Bytes  `json:"sigs"`
+}
+
+// NewSubmission returns the Submission of the transaction `tx` of the channel
+// with parameters `params` that is disputed at `adjudicator`.
+func NewSubmission(adjudicator common.Address, params *channel.Params, tx channel.Transaction) (*Submission, error) {
+	if len(tx.Allocation.Assets) != 1 || len(tx.Allocation.Locked) != 0 {
+		return nil, errors.New("only single-asset channels without sub-channels are supported")
+	}
+	s := &Submission{
+		Adjudicator:       adjudicator,
+		ChannelID:         tx.ID,
+		ChallengeDuration: params.ChallengeDuration,
+		Nonce:             (*hexutil.Big)(params.Nonce),
+		Version:           tx.Version,
+		Asset:             ethwallet.AsEthAddr(tx.Allocation.Assets[0].(*ethwallet.Address)),
+		IsFinal:           tx.IsFinal,
+	}
+	for _, p := range params.Parts {
+		s.Parts = append(s.Parts, ethwallet.AsEthAddr(p))
+	}
+	for _, bal := range tx.Allocation.Balances[0] {
+		s.Balances = append(s.Balances, (*hexutil.Big)(new(big.Int).Set(bal)))
+	}
+	for _, sig := range tx.Sigs {
+		s.Sigs = append(s.Sigs, append(hexutil.Bytes(nil), sig...))
+	}
+	return s, nil
+}
+
+// SignedState reconstructs the channel parameters and transaction of `s`. It
+// fails if they do not match the submitted channel ID.
+func (s *Submission) SignedState() (*channel.Params, channel.Transaction, error) {
+	switch {
+	case len(s.Parts) != 2 || len(s.Balances) != 2:
+		return nil, channel.Transaction{}, errors.New("only two-party channels are supported")
+	case len(s.Sigs) != len(s.Parts):
+		return nil, channel.Transaction{}, errors.New("state is not fully signed")
+	case s.Nonce == nil:
+		return nil, channel.Transaction{}, errors.New("missing nonce")
+	}
+	parts := make([]wallet.Address, len(s.Parts))
+	for i, p := range s.Parts {
+		parts[i] = ethwallet.AsWalletAddr(p)
+	}
+	params, err := channel.NewParams(s.ChallengeDuration, parts, channel.NoApp(), (*big.Int)(s.Nonce), true, false)
+	if err != nil {
+		return nil, channel.Transaction{}, errors.WithMessage(err, "creating params")
+	}
+	if params.ID() != s.ChannelID {
+		return nil, channel.Transaction{}, errors.New("parameters do not match channel ID")
+	}
+
+	bals := make([]channel.Bal, len(s.Balances))
+	for i, bal := range s.Balances {
+		if bal == nil || bal.ToInt().Sign() < 0 {
+			return nil, channel.Transaction{}, errors.Errorf("invalid balance of participant %d", i)
+		}
+		bals[i] = new(big.Int).Set(bal.ToInt())
+	}
+	tx := channel.Transaction{
+		State: &channel.State{
+			ID:      params.ID(),
+			Version: s.Version,
+			App:     channel.NoApp(),
+			Allocation: channel.Allocation{
+				Assets:   []channel.Asset{ethwallet.AsWalletAddr(s.Asset)},
+				Balances: channel.Balances{bals},
+			},
+			Data:    channel.NoData(),
+			IsFinal: s.IsFinal,
+		},
+	}
+	for _, sig := range s.Sigs {
+		tx.Sigs = append(tx.Sigs, wallet.Sig(sig))
+	}
+	return params, tx, nil
+}
+
+// verify checks that `s` is consistent and signed by all participants.
+func (s *Submission) verify() error {
+	params, tx, err := s.SignedState()
+	if err != nil {
+		return err
+	}
+	for i, part := range params.Parts {
+		if ok, err := channel.Verify(part, tx.State, tx.Sigs[i]); err != nil || !ok {
+			return errors.Errorf("invalid signature of participant %d", i)
+		}
+	}
+	return nil
+}